@@ -0,0 +1,11 @@
+// Package front holds the browser client. The files are embedded so the
+// server binary runs from any working directory.
+package front
+
+import "embed"
+
+// Files are the client assets, rooted at this directory. The server
+// compresses them itself at startup.
+//
+//go:embed index.html style.css js
+var Files embed.FS
@@ -1,5 +1,22 @@
+import { csrfHeaders, setCSRFToken } from './utils.js';
+
 const API_BASE_URL = '/api';
 
+// apiError turns an API error response, {"error": {"code", "message",
+// "fields"}}, into an Error whose fields property maps request fields to
+// what is wrong with them.
+export async function apiError(response, fallback) {
+    let detail = {};
+    try {
+        detail = (await response.json()).error || {};
+    } catch {
+        // Not JSON; use the fallback message
+    }
+    const error = new Error(detail.message || fallback);
+    error.fields = detail.fields || {};
+    return error;
+}
+
 export async function checkSession() {
     try {
         const response = await fetch(`${API_BASE_URL}/session`, {
@@ -7,6 +24,7 @@ export async function checkSession() {
         });
 
         if (response.ok) {
+            setCSRFToken(response.headers.get('X-CSRF-Token'));
             return await response.json();
         }
         return null;
@@ -26,9 +44,10 @@ export async function login(emailOrNickname, password) {
         });
 
         if (!response.ok) {
-            const error = await response.json();
-            throw new Error(error.message || 'Login failed');
+            throw await apiError(response, 'Login failed');
         }
+        // {twoFactorRequired, twoFactorToken} if a code is needed too
+        return await response.json();
     } catch (error) {
         throw error;
     }
@@ -43,8 +62,7 @@ export async function register(user) {
         });
 
         if (!response.ok) {
-            const error = await response.json();
-            throw new Error(error.message || 'Registration failed');
+            throw await apiError(response, 'Registration failed');
         }
     } catch (error) {
         throw error;
@@ -55,10 +73,57 @@ export async function logout() {
     try {
         await fetch(`${API_BASE_URL}/logout`, {
             method: 'POST',
+            headers: csrfHeaders(),
             credentials: 'include'
         });
         window.location.reload();
     } catch (error) {
         console.error('Logout failed:', error);
     }
-}
\ No newline at end of file
+}
+// postJSON sends body to an API route and throws the API's error if it
+// fails.
+async function postJSON(path, body, fallback) {
+    const response = await fetch(`${API_BASE_URL}${path}`, {
+        method: 'POST',
+        headers: csrfHeaders({ 'Content-Type': 'application/json' }),
+        body: JSON.stringify(body),
+        credentials: 'include'
+    });
+    if (!response.ok) {
+        throw await apiError(response, fallback);
+    }
+    return response.json();
+}
+
+export function requestPasswordReset(email) {
+    return postJSON('/password-reset', { email }, 'Could not send a reset link');
+}
+
+export function resetPassword(token, password) {
+    return postJSON('/password-reset/confirm', { token, password }, 'Could not reset your password');
+}
+
+export function verifyEmail(token) {
+    return postJSON('/verify-email', { token }, 'Could not verify your email address');
+}
+
+export function resendVerification() {
+    return postJSON('/verify-email/resend', {}, 'Could not send a verification email');
+}
+
+export function loginTwoFactor(token, code) {
+    return postJSON('/login/2fa', { token, code }, 'Login failed');
+}
+
+export function setupTwoFactor() {
+    return postJSON('/2fa/setup', {}, 'Could not start two-factor setup');
+}
+
+export function enableTwoFactor(code) {
+    return postJSON('/2fa/enable', { code }, 'Could not turn on two-factor authentication');
+}
+
+export function disableTwoFactor(password) {
+    return postJSON('/2fa/disable', { password }, 'Could not turn off two-factor authentication');
+}
@@ -1,5 +1,9 @@
+import { debounce, formatDate } from './utils.js';
+
 let socket = null;
 let currentChatUser = null;
+const conversations = new Map();
+const users = new Map();
 
 export function initChat() {
     // Connect to WebSocket
@@ -7,17 +11,21 @@ export function initChat() {
 
     socket.onopen = () => {
         console.log('WebSocket connected');
-        // Request online users
-        socket.send(JSON.stringify({ type: 'get_online_users' }));
+        // Request the user list; presence frames keep it current
+        socket.send(JSON.stringify({ type: 'get_users' }));
     };
 
     socket.onmessage = (event) => {
-        const message = JSON.parse(event.data);
-        handleSocketMessage(message);
+        // The server batches queued messages into one frame, one per line
+        event.data.split('\n').forEach(line => {
+            handleSocketMessage(JSON.parse(line));
+        });
     };
 
-    socket.onclose = () => {
-        console.log('WebSocket disconnected');
+    socket.onclose = (event) => {
+        console.log(`WebSocket closed (${event.code}${event.reason ? `: ${event.reason}` : ''})`);
+        // 1012: the account changed, e.g. a new role; reload to pick it up
+        if (event.code === 1012) window.location.reload();
     };
 
     // Event listeners for UI
@@ -25,38 +33,144 @@ export function initChat() {
         user.addEventListener('click', () => openChat(user.dataset.userId));
     });
 
+    loadConversations();
+
     document.getElementById('send-message').addEventListener('click', sendMessage);
-    document.getElementById('message-input').addEventListener('keypress', (e) => {
+    const input = document.getElementById('message-input');
+    input.addEventListener('keypress', (e) => {
         if (e.key === 'Enter') sendMessage();
     });
+    input.addEventListener('input', () => {
+        if (input.value.trim()) {
+            notifyTyping();
+        } else {
+            stopTyping();
+        }
+    });
+}
+
+// typing_start is repeated while the user keeps typing so the server-side
+// indicator (which expires after a few seconds) stays on.
+const typingRefreshInterval = 3000;
+const typingIdleDelay = 4000;
+let typingSentAt = 0;
+let typingTarget = null;
+const stopTypingWhenIdle = debounce(() => stopTyping(), typingIdleDelay);
+
+function notifyTyping() {
+    if (!currentChatUser) return;
+    if (typingTarget !== currentChatUser) {
+        stopTyping();
+    }
+    const now = Date.now();
+    if (now - typingSentAt > typingRefreshInterval) {
+        typingTarget = currentChatUser;
+        typingSentAt = now;
+        socket.send(JSON.stringify({
+            type: 'typing_start',
+            payload: { receiverId: Number(currentChatUser) }
+        }));
+    }
+    stopTypingWhenIdle();
+}
+
+function stopTyping() {
+    if (!typingTarget) return;
+    socket.send(JSON.stringify({
+        type: 'typing_stop',
+        payload: { receiverId: Number(typingTarget) }
+    }));
+    typingTarget = null;
+    typingSentAt = 0;
+}
+
+function showTyping(payload) {
+    const indicator = document.getElementById('typing-indicator');
+    if (String(payload.userId) !== String(currentChatUser)) return;
+    indicator.textContent = payload.typing ? 'typing…' : '';
+    indicator.classList.toggle('hidden', !payload.typing);
 }
 
 function openChat(userId) {
+    stopTyping();
     currentChatUser = userId;
+    showTyping({ userId, typing: false });
     document.querySelector('.chat-container').classList.remove('hidden');
     document.getElementById('chat-title').textContent = `Chat with ${userId}`;
     loadChatHistory(userId);
 }
 
+const historyPageSize = 10;
+let historyCursor = null;
+let loadingHistory = false;
+
 function loadChatHistory(userId) {
-    fetch(`/api/messages?userId=${userId}`)
+    const chatContainer = document.getElementById('chat-messages');
+    chatContainer.innerHTML = '';
+    historyCursor = null;
+    chatContainer.onscroll = debounce(() => {
+        if (chatContainer.scrollTop < 20) loadOlderMessages(userId);
+    }, 100);
+
+    fetchHistoryPage(userId).then(messages => {
+        messages.forEach(msg => chatContainer.appendChild(renderHistoryMessage(msg)));
+        chatContainer.scrollTop = chatContainer.scrollHeight;
+    });
+}
+
+function loadOlderMessages(userId) {
+    if (!historyCursor || loadingHistory || userId !== currentChatUser) return;
+
+    const chatContainer = document.getElementById('chat-messages');
+    fetchHistoryPage(userId, historyCursor).then(messages => {
+        // Keep the viewport anchored on the message that was at the top
+        const previousHeight = chatContainer.scrollHeight;
+        const fragment = document.createDocumentFragment();
+        messages.forEach(msg => fragment.appendChild(renderHistoryMessage(msg)));
+        chatContainer.prepend(fragment);
+        chatContainer.scrollTop = chatContainer.scrollHeight - previousHeight;
+    });
+}
+
+function fetchHistoryPage(userId, before) {
+    loadingHistory = true;
+    const cursor = before ? `&before=${before}` : '';
+    return fetch(`/api/messages?userId=${userId}&limit=${historyPageSize}${cursor}`)
         .then(response => response.json())
-        .then(messages => {
-            const chatContainer = document.getElementById('chat-messages');
-            chatContainer.innerHTML = '';
-
-            messages.forEach(msg => {
-                const messageElement = document.createElement('div');
-                messageElement.classList.add('message', msg.sender === currentUser.id ? 'sent' : 'received');
-                messageElement.innerHTML = `
-                    <div class="message-content">${msg.content}</div>
-                    <div class="message-time">${new Date(msg.timestamp).toLocaleTimeString()}</div>
-                `;
-                chatContainer.appendChild(messageElement);
-            });
-
-            chatContainer.scrollTop = chatContainer.scrollHeight;
-        });
+        .then(page => {
+            historyCursor = page.nextCursor || null;
+            return page.messages;
+        })
+        .finally(() => { loadingHistory = false; });
+}
+
+function renderHistoryMessage(msg) {
+    const direction = String(msg.senderId) === String(currentChatUser) ? 'received' : 'sent';
+    return messageNode(msg.id, msg.content, msg.createdAt, msg.status, direction);
+}
+
+// messageNode builds one chat message. Content, status and time are set as
+// text, never parsed as HTML, since they come from other users.
+function messageNode(id, content, time, status, direction) {
+    const messageElement = document.createElement('div');
+    messageElement.classList.add('message', direction);
+    messageElement.dataset.messageId = id;
+
+    const contentElement = document.createElement('div');
+    contentElement.className = 'message-content';
+    contentElement.textContent = content;
+    const timeElement = document.createElement('div');
+    timeElement.className = 'message-time';
+    timeElement.textContent = new Date(time).toLocaleTimeString();
+    messageElement.append(contentElement, timeElement);
+
+    if (direction === 'sent') {
+        const statusElement = document.createElement('div');
+        statusElement.className = 'message-status';
+        statusElement.textContent = status;
+        messageElement.appendChild(statusElement);
+    }
+    return messageElement;
 }
 
 function sendMessage() {
@@ -64,66 +178,137 @@ function sendMessage() {
     const content = input.value.trim();
 
     if (content && currentChatUser) {
-        const message = {
-            receiver: currentChatUser,
-            content: content,
-            timestamp: new Date().toISOString()
-        };
-
-        // Send via WebSocket for real-time
+        // The server stores the message, delivers it and acks with its ID
         socket.send(JSON.stringify({
             type: 'chat_message',
-            payload: message
+            payload: {
+                receiverId: Number(currentChatUser),
+                content: content,
+                clientId: `${Date.now()}-${Math.random().toString(36).slice(2)}`
+            }
         }));
 
-        // Also save to database
-        fetch('/api/messages', {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify(message)
-        });
-
         input.value = '';
+        stopTyping();
     }
 }
 
 function handleSocketMessage(message) {
     switch (message.type) {
-        case 'online_users':
-            updateOnlineUsers(message.payload);
+        case 'users':
+            users.clear();
+            message.payload.forEach(user => users.set(user.id, user));
+            renderUsers();
+            break;
+        case 'presence':
+            updatePresence(message.payload);
             break;
         case 'chat_message':
-            if (message.payload.sender === currentChatUser) {
+            if (String(message.payload.senderId) === String(currentChatUser)) {
                 appendMessage(message.payload);
+                showTyping({ userId: currentChatUser, typing: false });
+                markRead(message.payload.id);
+            } else if (String(message.payload.receiverId) === String(currentChatUser)) {
+                // Sent from another of my tabs or devices
+                appendMessage(message.payload, 'sent');
+            }
+            break;
+        case 'chat_message_ack':
+            if (String(message.payload.message.receiverId) === String(currentChatUser)) {
+                appendMessage(message.payload.message, 'sent');
             }
             break;
+        case 'conversation_update':
+            conversations.set(message.payload.userId, message.payload);
+            renderConversations();
+            break;
+        case 'error':
+            console.error(`Server rejected ${message.payload.type}:`, message.payload.message);
+            break;
         case 'user_typing':
-            // Show typing indicator
+            showTyping(message.payload);
+            break;
+        case 'message_read':
+            if (String(message.payload.readerId) === String(currentChatUser)) {
+                markSentAsRead(message.payload.messageId);
+            }
             break;
     }
 }
 
-function updateOnlineUsers(users) {
-    const list = document.getElementById('online-users-list');
-    list.innerHTML = '';
+// markRead tells the server the open chat has been read up to messageId;
+// the sender gets a message_read receipt.
+function markRead(messageId) {
+    socket.send(JSON.stringify({
+        type: 'message_read',
+        payload: { messageId }
+    }));
+}
 
-    users.forEach(user => {
-        const item = document.createElement('li');
-        item.textContent = user.nickname;
-        item.classList.add('user-item');
-        item.dataset.userId = user.id;
-        list.appendChild(item);
+function markSentAsRead(upToId) {
+    document.querySelectorAll('#chat-messages .message.sent').forEach(el => {
+        const status = el.querySelector('.message-status');
+        if (status && Number(el.dataset.messageId) <= upToId) {
+            status.textContent = 'read';
+        }
     });
 }
 
-function appendMessage(message) {
+function loadConversations() {
+    fetch('/api/conversations')
+        .then(response => response.json())
+        .then(list => {
+            conversations.clear();
+            list.forEach(c => conversations.set(c.userId, c));
+            renderConversations();
+        });
+}
+
+function renderConversations() {
+    const list = document.getElementById('conversations-list');
+    list.innerHTML = '';
+
+    [...conversations.values()]
+        .sort((a, b) => b.lastMessageId - a.lastMessageId)
+        .forEach(c => {
+            const item = document.createElement('li');
+            item.classList.add('conversation-item');
+            item.textContent = c.unreadCount > 0 ? `${c.nickname} (${c.unreadCount})` : c.nickname;
+            item.title = c.lastMessage;
+            item.addEventListener('click', () => openChat(c.userId));
+            list.appendChild(item);
+        });
+}
+
+function updatePresence(event) {
+    const user = users.get(event.userId) || { id: event.userId, nickname: event.nickname };
+    user.online = event.online;
+    user.lastSeenAt = event.lastSeenAt;
+    users.set(user.id, user);
+    renderUsers();
+}
+
+function renderUsers() {
+    const list = document.getElementById('users-list');
+    list.innerHTML = '';
+
+    [...users.values()]
+        .sort((a, b) => (b.online - a.online) || a.nickname.localeCompare(b.nickname))
+        .forEach(user => {
+            const item = document.createElement('li');
+            item.textContent = user.nickname;
+            item.classList.add('user-item', user.online ? 'online' : 'offline');
+            if (!user.online && user.lastSeenAt) {
+                item.title = `Last seen ${formatDate(user.lastSeenAt)}`;
+            }
+            item.dataset.userId = user.id;
+            item.addEventListener('click', () => openChat(user.id));
+            list.appendChild(item);
+        });
+}
+
+function appendMessage(message, direction = 'received') {
     const chatContainer = document.getElementById('chat-messages');
-    const messageElement = document.createElement('div');
-    messageElement.classList.add('message', 'received');
-    messageElement.innerHTML = `
-        <div class="message-content">${message.content}</div>
-        <div class="message-time">${new Date(message.timestamp).toLocaleTimeString()}</div>
-    `;
-    chatContainer.appendChild(messageElement);
+    chatContainer.appendChild(messageNode(message.id, message.content, message.timestamp, message.status, direction));
     chatContainer.scrollTop = chatContainer.scrollHeight;
 }
@@ -1,9 +1,55 @@
+import { csrfHeaders } from './utils.js';
+
 let currentPage = 1;
+let currentCategory = '';
 const postsPerPage = 10;
 
+// The logged-in user, for showing only the actions they are allowed
+let viewer = null;
+
+export function setViewer(user) {
+    viewer = user;
+}
+
+// canDelete mirrors the server's rule: authors delete their own posts,
+// moderators and admins any post.
+function canDelete(post) {
+    return viewer && (viewer.id === post.userId || viewer.role === 'admin' || viewer.role === 'moderator');
+}
+
+export function filterByCategory(slug) {
+    currentCategory = slug;
+    currentPage = 1;
+    document.getElementById('posts-list').innerHTML = '';
+    document.getElementById('load-more').disabled = false;
+    loadPosts();
+}
+
+export async function loadCategories() {
+    const response = await fetch('/api/categories');
+    if (!response.ok) return;
+    const categories = await response.json();
+
+    const list = document.getElementById('categories-list');
+    list.innerHTML = '';
+    const all = document.createElement('li');
+    all.textContent = 'All';
+    all.addEventListener('click', () => filterByCategory(''));
+    list.appendChild(all);
+
+    categories.forEach(category => {
+        const item = document.createElement('li');
+        item.textContent = `${category.name} (${category.postCount})`;
+        item.title = category.description || '';
+        item.addEventListener('click', () => filterByCategory(category.slug));
+        list.appendChild(item);
+    });
+}
+
 export async function loadPosts() {
     try {
-        const response = await fetch(`/api/posts?page=${currentPage}&limit=${postsPerPage}`);
+        const category = currentCategory ? `&category=${encodeURIComponent(currentCategory)}` : '';
+        const response = await fetch(`/api/posts?page=${currentPage}&limit=${postsPerPage}${category}`);
         const posts = await response.json();
 
         if (posts.length === 0) {
@@ -28,35 +74,80 @@ function renderPosts(posts) {
     posts.forEach(post => {
         const postElement = document.createElement('div');
         postElement.classList.add('post');
-        postElement.innerHTML = `
-            <div class="post-header">
-                <span class="author">${post.author}</span>
-                <span class="time">${new Date(post.createdAt).toLocaleString()}</span>
-            </div>
-            <h3 class="post-title">${post.title}</h3>
-            <div class="post-content">${post.content}</div>
-            <div class="post-categories">${post.categories.join(', ')}</div>
-            <div class="post-actions">
-                <button class="like-btn" data-post-id="${post.id}">Like</button>
-                <button class="comment-btn" data-post-id="${post.id}">Comment</button>
-            </div>
-            <div class="comments-section hidden" id="comments-${post.id}"></div>
-        `;
 
+        // User input is only ever set as text, never parsed as HTML
+        const header = document.createElement('div');
+        header.className = 'post-header';
+        header.append(
+            textNode('span', 'author', post.author),
+            textNode('span', 'time', new Date(post.createdAt).toLocaleString())
+        );
+
+        const actions = document.createElement('div');
+        actions.className = 'post-actions';
+        actions.append(
+            postButton('like-btn', 'Like', post.id, handleLike),
+            postButton('comment-btn', 'Comment', post.id, toggleComments)
+        );
+        if (canDelete(post)) {
+            actions.appendChild(postButton('delete-btn', 'Delete', post.id, handleDelete));
+        }
+
+        const comments = document.createElement('div');
+        comments.className = 'comments-section hidden';
+        comments.id = `comments-${post.id}`;
+
+        postElement.append(
+            header,
+            textNode('h3', 'post-title', post.title),
+            textNode('div', 'post-content', post.content),
+            textNode('div', 'post-categories', post.categories.map(c => c.name).join(', ')),
+            actions,
+            comments
+        );
         container.appendChild(postElement);
+    });
+}
+
+// textNode returns a new element of the given tag and class holding text.
+function textNode(tag, className, text) {
+    const element = document.createElement(tag);
+    element.className = className;
+    element.textContent = text;
+    return element;
+}
 
-        // Add event listeners
-        postElement.querySelector('.like-btn').addEventListener('click', handleLike);
-        postElement.querySelector('.comment-btn').addEventListener('click', toggleComments);
+function postButton(className, label, postId, onClick) {
+    const button = textNode('button', className, label);
+    button.dataset.postId = postId;
+    button.addEventListener('click', onClick);
+    return button;
+}
+
+async function handleDelete(event) {
+    if (!confirm('Delete this post?')) return;
+    const response = await fetch(`/api/posts/${event.target.dataset.postId}`, {
+        method: 'DELETE',
+        headers: csrfHeaders()
     });
+    if (response.ok) {
+        event.target.closest('.post').remove();
+    } else {
+        showError('Failed to delete post');
+    }
 }
 
 function handleLike(event) {
     const postId = event.target.dataset.postId;
-    fetch(`/api/posts/${postId}/like`, { method: 'POST' })
-        .then(response => {
-            if (response.ok) {
-                event.target.textContent = 'Liked!';
+    fetch(`/api/posts/${postId}/reactions`, {
+        method: 'POST',
+        headers: csrfHeaders({ 'Content-Type': 'application/json' }),
+        body: JSON.stringify({ reaction: 'like' })
+    })
+        .then(response => response.ok ? response.json() : null)
+        .then(summary => {
+            if (summary) {
+                event.target.textContent = summary.userReaction === 'like' ? `Liked (${summary.likes})` : `Like (${summary.likes})`;
             }
         });
 }
@@ -88,15 +179,16 @@ function renderComments(postId, comments) {
     container.innerHTML = '';
 
     comments.forEach(comment => {
+        const header = document.createElement('div');
+        header.className = 'comment-header';
+        header.append(
+            textNode('span', 'author', comment.author),
+            textNode('span', 'time', new Date(comment.createdAt).toLocaleString())
+        );
+
         const commentElement = document.createElement('div');
         commentElement.classList.add('comment');
-        commentElement.innerHTML = `
-            <div class="comment-header">
-                <span class="author">${comment.author}</span>
-                <span class="time">${new Date(comment.createdAt).toLocaleString()}</span>
-            </div>
-            <div class="comment-content">${comment.content}</div>
-        `;
+        commentElement.append(header, textNode('div', 'comment-content', comment.content));
         container.appendChild(commentElement);
     });
 
@@ -117,10 +209,10 @@ function submitComment(event) {
     const content = document.getElementById(`comment-input-${postId}`).value.trim();
 
     if (content) {
-        fetch(`/api/posts/${postId}/comments`, {
+        fetch('/api/comments', {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ content })
+            headers: csrfHeaders({ 'Content-Type': 'application/json' }),
+            body: JSON.stringify({ postId: Number(postId), content })
         })
             .then(response => {
                 if (response.ok) {
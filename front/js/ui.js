@@ -6,6 +6,17 @@ import { showError } from './utils.js';
 let currentUser = null;
 
 export async function initApp() {
+    // Links mailed to the user land on these pages
+    const token = new URLSearchParams(window.location.search).get('token');
+    if (token && window.location.pathname === '/reset-password') {
+        renderResetPasswordPage(token);
+        return;
+    }
+    if (token && window.location.pathname === '/verify-email') {
+        await renderVerifyEmailPage(token);
+        return;
+    }
+
     try {
         currentUser = await auth.checkSession();
         if (currentUser) {
@@ -30,8 +41,21 @@ function renderAuthPage() {
                 <input type="text" id="login-email" placeholder="Email or Nickname" required>
                 <input type="password" id="login-password" placeholder="Password" required>
                 <button id="login-btn">Login</button>
+                <a href="#" id="forgot-link" class="form-link">Forgot your password?</a>
                 <div id="login-error" class="error"></div>
             </div>
+            <div id="twofactor-form" class="form">
+                <p>Enter the code from your authenticator app, or one of your recovery codes.</p>
+                <input type="text" id="twofactor-code" placeholder="Code" autocomplete="one-time-code" required>
+                <button id="twofactor-btn">Verify</button>
+                <div id="twofactor-error" class="error"></div>
+            </div>
+            <div id="forgot-form" class="form">
+                <p>Enter your email and we'll send you a link to choose a new password.</p>
+                <input type="email" id="forgot-email" placeholder="Email" required>
+                <button id="forgot-btn">Send reset link</button>
+                <div id="forgot-error" class="error"></div>
+            </div>
             <div id="register-form" class="form">
                 <input type="text" id="reg-firstname" placeholder="First Name" required>
                 <input type="text" id="reg-lastname" placeholder="Last Name" required>
@@ -56,19 +80,88 @@ function renderAuthPage() {
     document.getElementById('register-tab').addEventListener('click', () => switchTab('register'));
     document.getElementById('login-btn').addEventListener('click', handleLogin);
     document.getElementById('register-btn').addEventListener('click', handleRegister);
+    document.getElementById('forgot-link').addEventListener('click', (e) => {
+        e.preventDefault();
+        switchTab('forgot');
+    });
+    document.getElementById('forgot-btn').addEventListener('click', handleForgotPassword);
+    document.getElementById('twofactor-btn').addEventListener('click', handleTwoFactorLogin);
+}
+
+async function handleForgotPassword() {
+    try {
+        const result = await auth.requestPasswordReset(document.getElementById('forgot-email').value);
+        showError('forgot-error', result.message, 'success');
+    } catch (error) {
+        showError('forgot-error', error.message);
+    }
+}
+
+// leaveAccountPage drops the token from the address bar and returns to the
+// app.
+function leaveAccountPage() {
+    window.history.replaceState(null, '', '/');
+    initApp();
+}
+
+function renderResetPasswordPage(token) {
+    document.getElementById('app').innerHTML = `
+        <div class="auth-container">
+            <div class="form active">
+                <h2>Choose a new password</h2>
+                <input type="password" id="reset-password" placeholder="New password" required>
+                <button id="reset-btn">Change password</button>
+                <div id="reset-error" class="error"></div>
+                <a href="/" id="reset-done" class="form-link">Back to login</a>
+            </div>
+        </div>
+    `;
+
+    document.getElementById('reset-done').addEventListener('click', (e) => {
+        e.preventDefault();
+        leaveAccountPage();
+    });
+    document.getElementById('reset-btn').addEventListener('click', async () => {
+        try {
+            const result = await auth.resetPassword(token, document.getElementById('reset-password').value);
+            showError('reset-error', result.message, 'success');
+            document.getElementById('reset-btn').disabled = true;
+        } catch (error) {
+            showError('reset-error', error.fields?.password || error.message);
+        }
+    });
+}
+
+async function renderVerifyEmailPage(token) {
+    document.getElementById('app').innerHTML = `
+        <div class="auth-container">
+            <div class="form active">
+                <h2>Email verification</h2>
+                <div id="verify-result" class="error"></div>
+                <a href="/" id="verify-done" class="form-link">Continue to the forum</a>
+            </div>
+        </div>
+    `;
+
+    document.getElementById('verify-done').addEventListener('click', (e) => {
+        e.preventDefault();
+        leaveAccountPage();
+    });
+    try {
+        const result = await auth.verifyEmail(token);
+        showError('verify-result', result.message, 'success');
+    } catch (error) {
+        showError('verify-result', error.message);
+    }
 }
 
 function switchTab(tab) {
     document.querySelectorAll('.form').forEach(f => f.classList.remove('active'));
     document.querySelectorAll('.tabs button').forEach(b => b.classList.remove('active'));
 
-    if (tab === 'login') {
-        document.getElementById('login-form').classList.add('active');
-        document.getElementById('login-tab').classList.add('active');
-    } else {
-        document.getElementById('register-form').classList.add('active');
-        document.getElementById('register-tab').classList.add('active');
-    }
+    document.getElementById(`${tab}-form`).classList.add('active');
+    // The forgotten password and two-factor forms have no tab of their own
+    document.getElementById(`${tab}-tab`)?.classList.add('active');
 }
 
 async function handleLogin() {
@@ -76,13 +169,32 @@ async function handleLogin() {
     const password = document.getElementById('login-password').value;
 
     try {
-        await auth.login(emailOrNickname, password);
+        const result = await auth.login(emailOrNickname, password);
+        if (result.twoFactorRequired) {
+            twoFactorToken = result.twoFactorToken;
+            switchTab('twofactor');
+            document.getElementById('twofactor-code').focus();
+            return;
+        }
         initApp(); // Reload app
     } catch (error) {
         showError('login-error', error.message);
     }
 }
 
+// The token from a correct password, waiting for a two-factor code
+let twoFactorToken = null;
+
+async function handleTwoFactorLogin() {
+    try {
+        await auth.loginTwoFactor(twoFactorToken, document.getElementById('twofactor-code').value);
+        twoFactorToken = null;
+        initApp();
+    } catch (error) {
+        showError('twofactor-error', error.message);
+    }
+}
+
 async function handleRegister() {
     const user = {
         firstName: document.getElementById('reg-firstname').value,
@@ -94,12 +206,37 @@ async function handleRegister() {
         gender: document.getElementById('reg-gender').value
     };
 
+    showFieldErrors({});
     try {
         await auth.register(user);
         showError('register-error', 'Registration successful. Please login.', 'success');
         switchTab('login');
     } catch (error) {
-        showError('register-error', error.message);
+        const fields = error.fields || {};
+        showFieldErrors(fields);
+        const messages = Object.values(fields);
+        showError('register-error', messages.length ? messages.join('. ') : error.message);
+    }
+}
+
+// Registration form inputs by the API field they submit
+const registerInputs = {
+    firstName: 'reg-firstname',
+    lastName: 'reg-lastname',
+    email: 'reg-email',
+    nickname: 'reg-nickname',
+    password: 'reg-password',
+    age: 'reg-age',
+    gender: 'reg-gender'
+};
+
+// showFieldErrors marks the registration inputs the server rejected and
+// clears the mark from the rest.
+function showFieldErrors(fields) {
+    for (const [field, id] of Object.entries(registerInputs)) {
+        const input = document.getElementById(id);
+        input.classList.toggle('invalid', field in fields);
+        input.title = fields[field] || '';
     }
 }
 
@@ -109,23 +246,30 @@ function renderMainApp() {
             <h1>Real-Time Forum</h1>
             <div class="user-info">
                 <span>Welcome, ${currentUser.nickname}</span>
+                <button id="security-btn">Security</button>
                 <button id="logout-btn">Logout</button>
             </div>
         </header>
+        ${currentUser.emailVerifiedAt ? '' : `
+        <div id="verify-banner" class="notice">
+            Please confirm your email address using the link we sent you.
+            <button id="resend-verification">Send it again</button>
+            <span id="verify-banner-status"></span>
+        </div>`}
+        <div id="security-panel" class="notice hidden"></div>
         <main>
             <div class="sidebar">
-                <div class="online-users">
-                    <h2>Online Users</h2>
-                    <ul id="online-users-list"></ul>
+                <div class="users">
+                    <h2>Users</h2>
+                    <ul id="users-list"></ul>
+                </div>
+                <div class="conversations">
+                    <h2>Conversations</h2>
+                    <ul id="conversations-list"></ul>
                 </div>
                 <div class="categories">
                     <h2>Categories</h2>
-                    <ul>
-                        <li>General</li>
-                        <li>Technology</li>
-                        <li>Gaming</li>
-                        <!-- More categories -->
-                    </ul>
+                    <ul id="categories-list"></ul>
                 </div>
             </div>
             <div class="content">
@@ -140,6 +284,7 @@ function renderMainApp() {
                         <button id="close-chat">✕</button>
                     </div>
                     <div id="chat-messages"></div>
+                    <div id="typing-indicator" class="hidden"></div>
                     <div class="chat-input">
                         <input type="text" id="message-input" placeholder="Type a message...">
                         <button id="send-message">Send</button>
@@ -150,10 +295,91 @@ function renderMainApp() {
     `;
 
     // Load initial data
+    posts.setViewer(currentUser);
+    posts.loadCategories();
     posts.loadPosts();
     chat.initChat();
 
     // Event listeners
     document.getElementById('logout-btn').addEventListener('click', auth.logout);
+    document.getElementById('security-btn').addEventListener('click', toggleSecurityPanel);
     document.getElementById('load-more').addEventListener('click', posts.loadMorePosts);
-}
\ No newline at end of file
+    document.getElementById('resend-verification')?.addEventListener('click', async (e) => {
+        const status = document.getElementById('verify-banner-status');
+        try {
+            const result = await auth.resendVerification();
+            status.textContent = result.message;
+            e.target.disabled = true;
+        } catch (error) {
+            status.textContent = error.message;
+        }
+    });
+}
+
+function toggleSecurityPanel() {
+    const panel = document.getElementById('security-panel');
+    if (panel.classList.toggle('hidden')) {
+        return;
+    }
+    if (currentUser.twoFactorEnabled) {
+        panel.innerHTML = `
+            Two-factor authentication is on.
+            <input type="password" id="twofactor-password" placeholder="Password to turn it off">
+            <button id="twofactor-disable">Turn off</button>
+            <span id="security-status"></span>
+        `;
+        document.getElementById('twofactor-disable').addEventListener('click', async () => {
+            try {
+                const result = await auth.disableTwoFactor(document.getElementById('twofactor-password').value);
+                currentUser.twoFactorEnabled = false;
+                panel.textContent = result.message;
+            } catch (error) {
+                document.getElementById('security-status').textContent = error.fields?.password || error.message;
+            }
+        });
+    } else {
+        panel.innerHTML = `
+            Two-factor authentication is off.
+            <button id="twofactor-setup">Turn on</button>
+            <span id="security-status"></span>
+        `;
+        document.getElementById('twofactor-setup').addEventListener('click', startTwoFactorSetup);
+    }
+}
+
+async function startTwoFactorSetup() {
+    const panel = document.getElementById('security-panel');
+    let setup;
+    try {
+        setup = await auth.setupTwoFactor();
+    } catch (error) {
+        document.getElementById('security-status').textContent = error.message;
+        return;
+    }
+
+    // The otpauth:// link is what a QR code for the app would hold; on a
+    // phone it opens the authenticator directly
+    panel.innerHTML = `
+        <p>Add the forum to your authenticator app with <a id="twofactor-uri">this link</a>,
+        or enter the key <code id="twofactor-secret"></code> by hand. Then enter the code it shows.</p>
+        <input type="text" id="twofactor-confirm-code" placeholder="Code" autocomplete="one-time-code">
+        <button id="twofactor-enable">Confirm</button>
+        <span id="security-status"></span>
+    `;
+    document.getElementById('twofactor-uri').href = setup.uri;
+    document.getElementById('twofactor-secret').textContent = setup.secret;
+    document.getElementById('twofactor-enable').addEventListener('click', async () => {
+        try {
+            const result = await auth.enableTwoFactor(document.getElementById('twofactor-confirm-code').value);
+            currentUser.twoFactorEnabled = true;
+            panel.innerHTML = `
+                <p>Two-factor authentication is on. Keep these recovery codes somewhere safe;
+                each one logs you in once if you lose your authenticator. They won't be shown again.</p>
+                <pre id="recovery-codes"></pre>
+            `;
+            document.getElementById('recovery-codes').textContent = result.recoveryCodes.join('\n');
+        } catch (error) {
+            document.getElementById('security-status').textContent = error.fields?.code || error.message;
+        }
+    });
+}
@@ -18,6 +18,19 @@ export function formatDate(dateString) {
     });
 }
 
+// The current session's CSRF token, from /api/session. Requests that
+// change anything must send it in the X-CSRF-Token header.
+let csrfToken = '';
+
+export function setCSRFToken(token) {
+    csrfToken = token || '';
+}
+
+// csrfHeaders returns headers with the CSRF token added.
+export function csrfHeaders(headers = {}) {
+    return csrfToken ? { ...headers, 'X-CSRF-Token': csrfToken } : headers;
+}
+
 export async function fetchWithAuth(url, options = {}) {
     const response = await fetch(url, {
         ...options,
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"real-time-forum/internal/services"
+)
+
+func (a *API) accountService() *services.AccountService {
+	return &services.AccountService{DB: a.DB, Mailer: a.Mailer, BaseURL: a.Config.Mail.BaseURL}
+}
+
+// ResendVerificationHandler mails the logged-in user a new verification
+// link.
+func (a *API) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.accountService().SendVerification(currentUser(r).ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeMessage(w, http.StatusAccepted, "Verification email sent")
+}
+
+func (a *API) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := a.accountService().VerifyEmail(body.Token); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, "Email address verified")
+}
+
+// RequestPasswordResetHandler mails a reset link if the email is
+// registered. The response is the same either way, and the lookup and
+// mail happen after it is sent so its timing doesn't tell either.
+func (a *API) RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body.Email == "" {
+		writeError(w, r, services.FieldErrors(map[string]string{"email": "email is required"}))
+		return
+	}
+
+	accountService := a.accountService()
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		if err := accountService.RequestPasswordReset(body.Email); err != nil {
+			log.Printf("Password reset request failed: %v", err)
+		}
+	}()
+	writeMessage(w, http.StatusAccepted, "If that email is registered, a reset link is on its way")
+}
+
+// ResetPasswordHandler sets a new password from a reset link. The user is
+// logged out everywhere and has to log in again.
+func (a *API) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	userID, err := a.accountService().ResetPassword(body.Token, body.Password)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	a.Hub.DisconnectUser(userID)
+	a.clearSessionCookie(w)
+
+	writeMessage(w, http.StatusOK, "Password changed; log in with your new password")
+}
@@ -2,39 +2,56 @@ package api
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"real-time-forum/internal/auth"
+	"real-time-forum/internal/config"
+	"real-time-forum/internal/csrf"
+	"real-time-forum/internal/mail"
 	"real-time-forum/internal/models"
 	"real-time-forum/internal/services"
 	"real-time-forum/internal/websocket"
+
+	"github.com/gorilla/mux"
 )
 
 type API struct {
-	DB  *sql.DB
-	Hub *websocket.Hub
+	DB     *sql.DB
+	Hub    *websocket.Hub
+	Config *config.Config
+	Mailer mail.Mailer
+	limits *limits
+	csrf   *csrf.Protector
+	// background tracks work that outlives its request, such as mail sent
+	// after the response, so shutdown can wait for it.
+	background *sync.WaitGroup
 }
 
 func (a *API) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &user); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	userService := services.UserService{DB: a.DB}
 	if err := userService.Register(&user); err != nil {
-		log.Printf("Registration failed: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
+	// The account works without a verified email, so a mail failure
+	// doesn't fail the registration; the user can ask for another link
+	if err := a.accountService().SendVerification(user.ID); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
+	writeMessage(w, http.StatusCreated, "User registered successfully")
 }
 
 func (a *API) LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -43,69 +60,66 @@ func (a *API) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		Password        string `json:"password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &credentials); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	userService := services.UserService{DB: a.DB}
-	token, err := userService.Login(credentials.EmailOrNickname, credentials.Password)
-	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	account, ip := loginAccount(credentials.EmailOrNickname), clientIP(r)
+	if err := a.limits.checkLogin(account, ip); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(24 * time.Hour),
-	})
-
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
-}
-
-func (a *API) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_token")
+	userService := services.UserService{DB: a.DB, SessionDuration: a.Config.Session.Duration}
+	result, err := userService.Login(credentials.EmailOrNickname, credentials.Password, r.UserAgent(), ip)
 	if err != nil {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			a.limits.loginFailed(account, ip)
+		}
+		writeError(w, r, err)
 		return
 	}
 
-	userService := services.UserService{DB: a.DB}
-	if err := userService.Logout(cookie.Value); err != nil {
-		http.Error(w, "Logout failed", http.StatusInternalServerError)
+	if result.TwoFactorToken != "" {
+		// The session is only started, and the account's failures cleared,
+		// once LoginTwoFactorHandler gets a code
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"twoFactorRequired": true,
+			"twoFactorToken":    result.TwoFactorToken,
+		})
 		return
 	}
+	a.limits.loginSucceeded(account)
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Unix(0, 0),
-	})
+	a.setSessionCookie(w, result.Token)
+	writeJSON(w, http.StatusOK, map[string]string{"token": result.Token})
+}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+func (a *API) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Logging out with an expired session still clears the cookie
+	if session := currentSession(r); session != nil {
+		cookie, _ := r.Cookie("session_token")
+		userService := services.UserService{DB: a.DB}
+		if err := userService.Logout(cookie.Value); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		a.Hub.DisconnectSession(session.ID)
+	}
+
+	a.clearSessionCookie(w)
+
+	writeMessage(w, http.StatusOK, "Logged out successfully")
 }
 
 func (a *API) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
-	websocket.ServeWs(a.Hub, w, r, a.DB)
+	websocket.ServeWs(a.Hub, w, r, a.DB, currentSession(r), currentUser(r))
 }
 
 // Similar handlers for posts, comments, chat, etc.
 func (a *API) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := a.getSessionUser(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
+	user := currentUser(r)
 
 	var post struct {
 		Title      string   `json:"title"`
@@ -113,161 +127,463 @@ func (a *API) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 		Categories []string `json:"categories"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &post); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	postService := services.PostService{DB: a.DB}
 	if err := postService.CreatePost(user.ID, post.Title, post.Content, post.Categories); err != nil {
-		log.Printf("Create post failed: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Post created successfully"})
+	writeMessage(w, http.StatusCreated, "Post created successfully")
+}
+
+// DeletePostHandler deletes a post, if it is the user's own or they may
+// delete any post.
+func (a *API) DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, errInvalidID)
+		return
+	}
+
+	postService := services.PostService{DB: a.DB}
+	if err := postService.DeletePost(currentUser(r), postID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeMessage(w, http.StatusOK, "Post deleted")
 }
 
 func (a *API) GetPostsHandler(w http.ResponseWriter, r *http.Request) {
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	page, limit := parsePage(r, 10)
+
+	category := r.URL.Query().Get("category")
+	if category != "" {
+		categoryService := services.CategoryService{DB: a.DB}
+		if _, err := categoryService.GetCategory(category); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
 
-	if page < 1 {
-		page = 1
+	postService := services.PostService{DB: a.DB}
+	posts, err := postService.GetPosts(viewerID(r), category, limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
-	if limit < 1 {
-		limit = 10
+
+	writeJSON(w, http.StatusOK, posts)
+}
+
+func (a *API) GetPostHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, errInvalidID)
+		return
 	}
+	page, limit := parsePage(r, 20)
 
 	postService := services.PostService{DB: a.DB}
-	posts, err := postService.GetPosts(limit, (page-1)*limit)
+	post, err := postService.GetPostDetail(viewerID(r), postID, limit, (page-1)*limit)
 	if err != nil {
-		log.Printf("Get posts failed: %v", err)
-		http.Error(w, "Failed to get posts", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	post.Page, post.Limit = page, limit
 
-	json.NewEncoder(w).Encode(posts)
+	writeJSON(w, http.StatusOK, post)
 }
 
-func (a *API) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := a.getSessionUser(r)
+func (a *API) GetCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, errInvalidID)
+		return
+	}
+	page, limit := parsePage(r, 20)
+
+	viewerID := viewerID(r)
+	postService := services.PostService{DB: a.DB}
+	if _, err := postService.GetPost(viewerID, postID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	comments, err := postService.GetComments(viewerID, postID, limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func (a *API) GetCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categoryService := services.CategoryService{DB: a.DB}
+	categories, err := categoryService.ListCategories()
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
+	writeJSON(w, http.StatusOK, categories)
+}
+
+func (a *API) CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	categoryService := services.CategoryService{DB: a.DB}
+	category, err := categoryService.CreateCategory(body.Name, body.Description)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, category)
+}
+
+func (a *API) DeleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	categoryService := services.CategoryService{DB: a.DB}
+	if err := categoryService.DeleteCategory(mux.Vars(r)["slug"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeMessage(w, http.StatusOK, "Category deleted")
+}
+
+func (a *API) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := services.ParseSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page, limit := parsePage(r, 20)
+
+	searchService := services.SearchService{DB: a.DB}
+	results, err := searchService.Search(viewerID(r), query, limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (a *API) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
 	var comment struct {
 		PostID  int    `json:"postId"`
 		Content string `json:"content"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &comment); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	postService := services.PostService{DB: a.DB}
 	if err := postService.CreateComment(comment.PostID, user.ID, comment.Content); err != nil {
-		log.Printf("Create comment failed: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Comment created successfully"})
+	writeMessage(w, http.StatusCreated, "Comment created successfully")
 }
 
-func (a *API) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := a.getSessionUser(r)
+func (a *API) ReactToPostHandler(w http.ResponseWriter, r *http.Request) {
+	a.react(w, r, "post")
+}
+
+func (a *API) ReactToCommentHandler(w http.ResponseWriter, r *http.Request) {
+	a.react(w, r, "comment")
+}
+
+// react toggles, switches or clears the user's reaction on the post or
+// comment named in the URL and pushes the new counts to every client.
+func (a *API) react(w http.ResponseWriter, r *http.Request, contentType string) {
+	user := currentUser(r)
+
+	contentID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, r, errInvalidID)
 		return
 	}
 
+	var body struct {
+		Reaction string `json:"reaction"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	reactionService := services.ReactionService{DB: a.DB}
+	summary, err := reactionService.React(user.ID, contentType, contentID, body.Reaction)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Everyone sees the new counts; only the reacting user gets their own
+	// reaction back.
+	broadcast := *summary
+	broadcast.UserReaction = ""
+	a.Hub.BroadcastEvent("reaction_update", broadcast)
+
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (a *API) GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
 	otherUserID, _ := strconv.Atoi(r.URL.Query().Get("userId"))
 	if otherUserID == 0 {
-		http.Error(w, "Missing userId parameter", http.StatusBadRequest)
+		writeError(w, r, services.ValidationError("missing userId parameter"))
 		return
 	}
 
+	query := r.URL.Query()
+	before, _ := strconv.Atoi(query.Get("before"))
+	after, _ := strconv.Atoi(query.Get("after"))
+	if before > 0 && after > 0 {
+		writeError(w, r, services.ValidationError("use either before or after, not both"))
+		return
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit < 1 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
 	chatService := services.ChatService{DB: a.DB}
-	messages, err := chatService.GetMessages(user.ID, otherUserID, 100, 0)
+	page, err := chatService.GetMessages(user.ID, otherUserID, before, after, limit)
 	if err != nil {
-		log.Printf("Get messages failed: %v", err)
-		http.Error(w, "Failed to get messages", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(messages)
+	// Opening a thread (loading its newest page) reads it
+	if before == 0 {
+		readUpTo, err := chatService.MarkConversationRead(user.ID, otherUserID, 0)
+		if err != nil {
+			log.Printf("Mark conversation read failed: %v", err)
+		} else if readUpTo > 0 {
+			a.Hub.PushReadReceipt(user.ID, otherUserID, readUpTo)
+			a.Hub.PushConversationUpdate(a.DB, user.ID, otherUserID)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, page)
 }
 
-func (a *API) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := a.getSessionUser(r)
+func (a *API) GetConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	chatService := services.ChatService{DB: a.DB}
+	conversations, err := chatService.GetConversations(user.ID)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
+	writeJSON(w, http.StatusOK, conversations)
+}
+
+func (a *API) MarkConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	otherUserID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		writeError(w, r, errInvalidID)
+		return
+	}
+
+	// An empty body marks the whole conversation read
+	var body struct {
+		LastReadMessageID int `json:"lastReadMessageId"`
+	}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &body); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	chatService := services.ChatService{DB: a.DB}
+	readUpTo, err := chatService.MarkConversationRead(user.ID, otherUserID, body.LastReadMessageID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if readUpTo > 0 {
+		a.Hub.PushReadReceipt(user.ID, otherUserID, readUpTo)
+		a.Hub.PushConversationUpdate(a.DB, user.ID, otherUserID)
+	}
+
+	writeMessage(w, http.StatusOK, "Conversation marked read")
+}
+
+func (a *API) SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
 	var message struct {
 		ReceiverID int    `json:"receiverId"`
 		Content    string `json:"content"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &message); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	chatService := services.ChatService{DB: a.DB}
-	if err := chatService.SaveMessage(user.ID, message.ReceiverID, message.Content); err != nil {
-		log.Printf("Send message failed: %v", err)
-		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+	saved, err := chatService.SaveMessage(user.ID, message.ReceiverID, message.Content)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Deliver via WebSocket to both participants only
+	msg := websocket.NewChatMessage(saved, user.Nickname)
+	a.Hub.DeliverChatMessage(a.DB, &msg)
+
+	writeJSON(w, http.StatusCreated, msg)
+}
+
+// GetUsersHandler lists every registered user with their online status.
+func (a *API) GetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := a.Hub.UserList(a.DB)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// parsePage reads the page and limit query parameters. Pages start at 1 and
+// limit is capped at 100.
+func parsePage(r *http.Request, defaultLimit int) (page, limit int) {
+	page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
 
-	// Broadcast via WebSocket
-	msg := models.ChatMessage{
-		Sender:    user.Nickname,
-		Receiver:  strconv.Itoa(message.ReceiverID),
-		Content:   message.Content,
-		Timestamp: time.Now().Format(time.RFC3339),
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = defaultLimit
 	}
-	wsMessage := models.WebSocketMessage{
-		Type:    "chat_message",
-		Payload: msg,
+	if limit > 100 {
+		limit = 100
 	}
-	messageBytes, _ := json.Marshal(wsMessage)
-	a.Hub.Broadcast <- messageBytes
+	return page, limit
+}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Message sent successfully"})
+// clientIP returns the remote address of the request without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
-func (a *API) GetOnlineUsersHandler(w http.ResponseWriter, r *http.Request) {
-	onlineUsers := a.Hub.GetOnlineUsers()
-	json.NewEncoder(w).Encode(onlineUsers)
+func (a *API) setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   a.Config.Session.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Now().Add(a.Config.Session.Duration),
+	})
 }
 
-// Helper to get current user from session
-func (a *API) getSessionUser(r *http.Request) (*models.User, error) {
-	cookie, err := r.Cookie("session_token")
+func (a *API) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   a.Config.Session.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Unix(0, 0),
+	})
+}
+
+func (a *API) SessionCheckHandler(w http.ResponseWriter, r *http.Request) {
+	// The page reads its CSRF token from here
+	cookie, _ := r.Cookie("session_token")
+	w.Header().Set(csrf.Header, a.csrf.Token(cookie.Value))
+	writeJSON(w, http.StatusOK, currentUser(r))
+}
+
+func (a *API) GetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, user := currentSession(r), currentUser(r)
+
+	sessions, err := auth.ListSessions(a.DB, user.ID)
 	if err != nil {
-		return nil, err
+		writeError(w, r, err)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current.ID
 	}
-	return auth.ValidateSession(a.DB, cookie.Value)
+
+	writeJSON(w, http.StatusOK, sessions)
 }
 
-func (a *API) SessionCheckHandler(w http.ResponseWriter, r *http.Request) {
-	user, err := a.getSessionUser(r)
+func (a *API) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	current, user := currentSession(r), currentUser(r)
+
+	sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		// No valid session found
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		writeError(w, r, errInvalidID)
 		return
 	}
 
-	// Session is valid, return user info
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	if err := auth.RevokeSession(a.DB, user.ID, sessionID); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			err = services.NotFoundError("session not found")
+		}
+		writeError(w, r, err)
+		return
+	}
+	a.Hub.DisconnectSession(sessionID)
+
+	if sessionID == current.ID {
+		a.clearSessionCookie(w)
+	}
+
+	writeMessage(w, http.StatusOK, "Session revoked")
+}
+
+func (a *API) RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+
+	if err := auth.RevokeAllSessions(a.DB, user.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	a.Hub.DisconnectUser(user.ID)
+
+	a.clearSessionCookie(w)
+
+	writeMessage(w, http.StatusOK, "All sessions revoked")
 }
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"real-time-forum/internal/auth"
+	"real-time-forum/internal/csrf"
+	"real-time-forum/internal/models"
+)
+
+type contextKey int
+
+const (
+	sessionKey contextKey = iota
+	userKey
+)
+
+// authenticate resolves the session cookie once per request and stores the
+// session and its user in the request context. Requests without a valid
+// session carry on anonymously; whether they may proceed is up to the
+// route's Access.
+func (a *API) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session_token")
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, user, err := auth.LookupSession(a.DB, cookie.Value)
+		if err != nil {
+			if !errors.Is(err, auth.ErrSessionNotFound) {
+				writeError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := auth.TouchSession(a.DB, session, a.Config.Session.Duration); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), sessionKey, session)
+		ctx = context.WithValue(ctx, userKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// checkCSRF rejects state-changing requests made with a session unless
+// they carry the session's CSRF token. Requests without a session have no
+// one's identity to abuse and pass through.
+func (a *API) checkCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if csrf.Safe(r.Method) || currentSession(r) == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cookie, _ := r.Cookie("session_token")
+		if !a.csrf.Valid(cookie.Value, r.Header.Get(csrf.Header)) {
+			writeError(w, r, errCSRF)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentUser returns the logged-in user, or nil for anonymous requests.
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userKey).(*models.User)
+	return user
+}
+
+// currentSession returns the session the request was made with, or nil.
+func currentSession(r *http.Request) *models.Session {
+	session, _ := r.Context().Value(sessionKey).(*models.Session)
+	return session
+}
+
+// viewerID returns the ID of the logged-in user, or 0 for anonymous requests
+func viewerID(r *http.Request) int {
+	if user := currentUser(r); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// Access decides whether a request may reach a route. It returns the error
+// to respond with if not.
+type Access func(r *http.Request) error
+
+// Public lets anyone in.
+func Public(*http.Request) error { return nil }
+
+// Authenticated requires a logged-in user.
+func Authenticated(r *http.Request) error {
+	if currentUser(r) == nil {
+		return errNotAuthenticated
+	}
+	return nil
+}
+
+// Require lets in logged-in users for whom allowed returns true.
+func Require(allowed func(*models.User) bool) Access {
+	return func(r *http.Request) error {
+		user := currentUser(r)
+		if user == nil {
+			return errNotAuthenticated
+		}
+		if !allowed(user) {
+			return errForbidden
+		}
+		return nil
+	}
+}
+
+// Permit lets in logged-in users whose role grants perm.
+func Permit(perm auth.Permission) Access {
+	return Require(func(user *models.User) bool { return auth.Can(user.Role, perm) })
+}
+
+// contentAccess is the access to forum content, which is Public or
+// Authenticated depending on access.public_content.
+func (a *API) contentAccess() Access {
+	if a.Config.Access.PublicContent {
+		return Public
+	}
+	return Authenticated
+}
+
+// allow wraps h so that it only runs for requests access lets in.
+func allow(access Access, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := access(r); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		h(w, r)
+	})
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// SetRoleHandler changes another user's role and closes their websocket
+// connections.
+func (a *API) SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, errInvalidID)
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Otherwise the last admin could demote themselves by accident
+	if userID == currentUser(r).ID {
+		writeError(w, r, services.ValidationError("you can't change your own role"))
+		return
+	}
+
+	moderationService := services.ModerationService{DB: a.DB}
+	if err := moderationService.SetRole(userID, body.Role); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Websocket connections hold the role they connected with
+	a.Hub.RefreshUser(userID)
+	writeMessage(w, http.StatusOK, "Role updated")
+}
+
+// BanUserHandler bans a user and closes their websocket connections.
+func (a *API) BanUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, errInvalidID)
+		return
+	}
+
+	moderationService := services.ModerationService{DB: a.DB}
+	if err := moderationService.Ban(currentUser(r), userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	a.Hub.DisconnectUser(userID)
+
+	writeMessage(w, http.StatusOK, "User banned")
+}
+
+func (a *API) UnbanUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, errInvalidID)
+		return
+	}
+
+	moderationService := services.ModerationService{DB: a.DB}
+	if err := moderationService.Unban(currentUser(r), userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, "User unbanned")
+}
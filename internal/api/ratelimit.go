@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"real-time-forum/internal/config"
+	"real-time-forum/internal/ratelimit"
+)
+
+// limits are the rate limits and login lockouts the API applies. Nil
+// fields, as when rate limiting is disabled, limit nothing.
+type limits struct {
+	api, login, register, mail *ratelimit.Limiter
+	// Failed logins lock out the account tried and, with a higher
+	// threshold, the IP trying it, so guessing one account's password and
+	// trying one password against many accounts are both slowed down.
+	account, ip *ratelimit.Lockout
+	// Wrong two-factor codes lock out the user they were entered for.
+	twoFactor *ratelimit.Lockout
+}
+
+func newLimits(store ratelimit.Store, cfg config.RateLimitConfig) *limits {
+	if !cfg.Enabled {
+		return &limits{}
+	}
+	return &limits{
+		api:       ratelimit.NewLimiter(store, "api", cfg.API),
+		login:     ratelimit.NewLimiter(store, "login", cfg.Login),
+		register:  ratelimit.NewLimiter(store, "register", cfg.Register),
+		mail:      ratelimit.NewLimiter(store, "mail", cfg.Mail),
+		account:   ratelimit.NewLockout(store, "lockout:account", cfg.LoginFailures, cfg.LockoutBase, cfg.LockoutMax),
+		ip:        ratelimit.NewLockout(store, "lockout:ip", 4*cfg.LoginFailures, cfg.LockoutBase, cfg.LockoutMax),
+		twoFactor: ratelimit.NewLockout(store, "lockout:2fa", cfg.LoginFailures, cfg.LockoutBase, cfg.LockoutMax),
+	}
+}
+
+// limitByIP wraps next so that each client IP may only call it as often as
+// l allows.
+func limitByIP(l *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := l.Allow(clientIP(r)); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitAPI limits every API request per client IP.
+func (a *API) limitAPI(next http.Handler) http.Handler {
+	return limitByIP(a.limits.api, next)
+}
+
+// loginAccount is the lockout key for a login name, which may be an email
+// or a nickname in any case.
+func loginAccount(emailOrNickname string) string {
+	return strings.ToLower(strings.TrimSpace(emailOrNickname))
+}
+
+// checkLogin returns an error if logins from ip or to account are locked
+// out.
+func (l *limits) checkLogin(account, ip string) error {
+	if err := l.ip.Check(ip); err != nil {
+		return err
+	}
+	return l.account.Check(account)
+}
+
+func (l *limits) loginFailed(account, ip string) {
+	l.account.Fail(account)
+	l.ip.Fail(ip)
+}
+
+// loginSucceeded clears the account's failures. The IP's stay, so one
+// account the client controls can't be used to reset its count.
+func (l *limits) loginSucceeded(account string) {
+	l.account.Reset(account)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internal/ratelimit"
+	"real-time-forum/internal/services"
+)
+
+// errorResponse is the body of every error response:
+//
+//	{"error": {"code": "validation_error", "message": "...", "fields": {...}}}
+type errorResponse struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+var errorStatus = map[services.Kind]int{
+	services.KindValidation:   http.StatusBadRequest,
+	services.KindNotFound:     http.StatusNotFound,
+	services.KindConflict:     http.StatusConflict,
+	services.KindUnauthorized: http.StatusUnauthorized,
+	services.KindForbidden:    http.StatusForbidden,
+}
+
+// Errors raised by the handlers themselves
+var (
+	errNotAuthenticated = services.UnauthorizedError("not authenticated")
+	errForbidden        = services.ForbiddenError("you are not allowed to do that")
+	errCSRF             = services.ForbiddenError("missing or invalid CSRF token; reload the page")
+	errInvalidBody      = services.ValidationError("invalid request body")
+	errInvalidID        = services.ValidationError("invalid id")
+)
+
+// writeJSON sends v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// writeMessage sends a {"message": ...} confirmation.
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// writeError reports err in the error envelope. A *services.Error is
+// passed on to the client with its status and a *ratelimit.Error becomes a
+// 429 with Retry-After; anything else is logged and reported as a bare 500
+// so internal details never reach the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var limitErr *ratelimit.Error
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(limitErr.Seconds()))
+		writeJSON(w, http.StatusTooManyRequests, errorResponse{errorDetail{
+			Code:    "rate_limited",
+			Message: fmt.Sprintf("Too many requests. Try again in %d seconds.", limitErr.Seconds()),
+		}})
+		return
+	}
+
+	var serviceErr *services.Error
+	if errors.As(err, &serviceErr) {
+		if status, ok := errorStatus[serviceErr.Kind]; ok {
+			writeJSON(w, status, errorResponse{errorDetail{
+				Code:    serviceErr.Kind.Code(),
+				Message: serviceErr.Message,
+				Fields:  serviceErr.Fields,
+			}})
+			return
+		}
+	}
+
+	log.Printf("%s %s failed: %v", r.Method, r.URL.Path, err)
+	writeJSON(w, http.StatusInternalServerError, errorResponse{errorDetail{
+		Code:    "internal_error",
+		Message: "Something went wrong. Please try again.",
+	}})
+}
+
+// notFoundHandler answers requests for API routes that don't exist.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, services.NotFoundError("no such endpoint"))
+}
+
+// decodeJSON reads the request body into v.
+func decodeJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errInvalidBody
+	}
+	return nil
+}
@@ -2,49 +2,102 @@ package api
 
 import (
 	"database/sql"
+	"fmt"
+	"io/fs"
 	"net/http"
+	"os"
+	"sync"
 
+	"real-time-forum/front"
+	"real-time-forum/internal/auth"
+	"real-time-forum/internal/config"
+	"real-time-forum/internal/csrf"
+	"real-time-forum/internal/mail"
+	"real-time-forum/internal/ratelimit"
+	"real-time-forum/internal/static"
 	"real-time-forum/internal/websocket"
 
 	"github.com/gorilla/mux"
 )
 
-func SetupRouter(db *sql.DB, hub *websocket.Hub) *mux.Router {
-	api := &API{DB: db, Hub: hub}
+// SetupRouter builds the HTTP routes. Rate limits keep their state in
+// limits. Work handlers leave running after responding is added to
+// background, which the caller waits on before closing db.
+func SetupRouter(db *sql.DB, hub *websocket.Hub, cfg *config.Config, limits ratelimit.Store, background *sync.WaitGroup) (*mux.Router, error) {
+	mailer, err := mail.New(cfg.Mail)
+	if err != nil {
+		return nil, err
+	}
+
+	api := &API{
+		DB:     db,
+		Hub:    hub,
+		Config: cfg,
+		Mailer: mailer,
+		limits: newLimits(limits, cfg.RateLimit),
+		csrf:   csrf.New(cfg.Security.Secret),
+
+		background: background,
+	}
 
 	router := mux.NewRouter()
 
-	// API routes
+	// API routes. Each declares who may use it; the session is resolved
+	// once by authenticate and handlers read the user from the context.
+	content := api.contentAccess()
 	apiRouter := router.PathPrefix("/api").Subrouter()
-	apiRouter.HandleFunc("/register", api.RegisterHandler).Methods("POST")
-	apiRouter.HandleFunc("/login", api.LoginHandler).Methods("POST")
-	apiRouter.HandleFunc("/logout", api.LogoutHandler).Methods("POST")
-	apiRouter.HandleFunc("/session", api.SessionCheckHandler).Methods("GET") // Add this new route
-	apiRouter.HandleFunc("/posts", api.CreatePostHandler).Methods("POST")
-	apiRouter.HandleFunc("/posts", api.GetPostsHandler).Methods("GET")
-	// Add other API routes here...
-	apiRouter.HandleFunc("/comments", api.CreateCommentHandler).Methods("POST")
-	apiRouter.HandleFunc("/messages", api.GetMessagesHandler).Methods("GET")
-	apiRouter.HandleFunc("/messages", api.SendMessageHandler).Methods("POST")
-	apiRouter.HandleFunc("/users", api.GetOnlineUsersHandler).Methods("GET")
+	apiRouter.Use(api.limitAPI, api.authenticate, api.checkCSRF)
+	apiRouter.Handle("/register", limitByIP(api.limits.register, allow(Public, api.RegisterHandler))).Methods("POST")
+	apiRouter.Handle("/login", limitByIP(api.limits.login, allow(Public, api.LoginHandler))).Methods("POST")
+	apiRouter.Handle("/login/2fa", limitByIP(api.limits.login, allow(Public, api.LoginTwoFactorHandler))).Methods("POST")
+	apiRouter.Handle("/logout", allow(Public, api.LogoutHandler)).Methods("POST")
+	apiRouter.Handle("/verify-email", allow(Public, api.VerifyEmailHandler)).Methods("POST")
+	apiRouter.Handle("/verify-email/resend", limitByIP(api.limits.mail, allow(Authenticated, api.ResendVerificationHandler))).Methods("POST")
+	apiRouter.Handle("/password-reset", limitByIP(api.limits.mail, allow(Public, api.RequestPasswordResetHandler))).Methods("POST")
+	apiRouter.Handle("/password-reset/confirm", allow(Public, api.ResetPasswordHandler)).Methods("POST")
+	apiRouter.Handle("/2fa/setup", allow(Authenticated, api.SetupTwoFactorHandler)).Methods("POST")
+	apiRouter.Handle("/2fa/enable", allow(Authenticated, api.EnableTwoFactorHandler)).Methods("POST")
+	apiRouter.Handle("/2fa/disable", limitByIP(api.limits.login, allow(Authenticated, api.DisableTwoFactorHandler))).Methods("POST")
+	apiRouter.Handle("/session", allow(Authenticated, api.SessionCheckHandler)).Methods("GET")
+	apiRouter.Handle("/sessions", allow(Authenticated, api.GetSessionsHandler)).Methods("GET")
+	apiRouter.Handle("/sessions", allow(Authenticated, api.RevokeAllSessionsHandler)).Methods("DELETE")
+	apiRouter.Handle("/sessions/{id:[0-9]+}", allow(Authenticated, api.RevokeSessionHandler)).Methods("DELETE")
+	apiRouter.Handle("/posts", allow(Permit(auth.PermCreatePost), api.CreatePostHandler)).Methods("POST")
+	apiRouter.Handle("/posts", allow(content, api.GetPostsHandler)).Methods("GET")
+	apiRouter.Handle("/posts/{id:[0-9]+}", allow(content, api.GetPostHandler)).Methods("GET")
+	apiRouter.Handle("/posts/{id:[0-9]+}", allow(Authenticated, api.DeletePostHandler)).Methods("DELETE")
+	apiRouter.Handle("/posts/{id:[0-9]+}/comments", allow(content, api.GetCommentsHandler)).Methods("GET")
+	apiRouter.Handle("/posts/{id:[0-9]+}/reactions", allow(Authenticated, api.ReactToPostHandler)).Methods("POST")
+	apiRouter.Handle("/comments/{id:[0-9]+}/reactions", allow(Authenticated, api.ReactToCommentHandler)).Methods("POST")
+	apiRouter.Handle("/categories", allow(content, api.GetCategoriesHandler)).Methods("GET")
+	apiRouter.Handle("/categories", allow(Permit(auth.PermManageCategories), api.CreateCategoryHandler)).Methods("POST")
+	apiRouter.Handle("/categories/{slug}", allow(Permit(auth.PermManageCategories), api.DeleteCategoryHandler)).Methods("DELETE")
+	apiRouter.Handle("/search", allow(content, api.SearchHandler)).Methods("GET")
+	apiRouter.Handle("/comments", allow(Permit(auth.PermComment), api.CreateCommentHandler)).Methods("POST")
+	apiRouter.Handle("/messages", allow(Authenticated, api.GetMessagesHandler)).Methods("GET")
+	apiRouter.Handle("/messages", allow(Permit(auth.PermSendMessage), api.SendMessageHandler)).Methods("POST")
+	apiRouter.Handle("/conversations", allow(Authenticated, api.GetConversationsHandler)).Methods("GET")
+	apiRouter.Handle("/conversations/{userId:[0-9]+}/read", allow(Authenticated, api.MarkConversationReadHandler)).Methods("POST")
+	apiRouter.Handle("/users", allow(Authenticated, api.GetUsersHandler)).Methods("GET")
+	apiRouter.Handle("/users/{id:[0-9]+}/role", allow(Permit(auth.PermManageRoles), api.SetRoleHandler)).Methods("PUT")
+	apiRouter.Handle("/users/{id:[0-9]+}/ban", allow(Permit(auth.PermBanUser), api.BanUserHandler)).Methods("POST")
+	apiRouter.Handle("/users/{id:[0-9]+}/ban", allow(Permit(auth.PermBanUser), api.UnbanUserHandler)).Methods("DELETE")
+	apiRouter.NotFoundHandler = http.HandlerFunc(notFoundHandler)
 
 	// WebSocket endpoint
-	router.HandleFunc("/ws", api.WebSocketHandler)
-
-	// Create a file server for the 'front' directory
-	staticFileServer := http.FileServer(http.Dir("./front"))
-
-	// Serve JS files. http.StripPrefix is used to remove the '/js/' part
-	// so the file server can find the files in the root of the 'front' directory.
-	jsFileServer := http.FileServer(http.Dir("./front/js"))
-	router.PathPrefix("/js/").Handler(http.StripPrefix("/js/", jsFileServer))
-	// Serve the style.css file directly
-	router.Handle("/style.css", staticFileServer)
+	router.Handle("/ws", api.authenticate(allow(Authenticated, api.WebSocketHandler)))
 
-	// Serve the main index.html for the root path
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "./front/index.html")
-	})
+	// Everything else is the front end: embedded in the binary, or read
+	// from static.dir while developing it
+	var files fs.FS = front.Files
+	if cfg.Static.Dir != "" {
+		files = os.DirFS(cfg.Static.Dir)
+	}
+	staticHandler, err := static.New(files, cfg.Static.Dir != "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load front end: %w", err)
+	}
+	router.PathPrefix("/").Handler(staticHandler)
 
-	return router
+	return router, nil
 }
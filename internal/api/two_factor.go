@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"real-time-forum/internal/services"
+)
+
+// LoginTwoFactorHandler finishes a login for a user with two-factor
+// authentication, exchanging the token LoginHandler returned and a TOTP
+// or recovery code for a session.
+func (a *API) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	userService := services.UserService{DB: a.DB, SessionDuration: a.Config.Session.Duration}
+	userID, err := userService.PendingLogin(body.Token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Codes are short, so wrong ones lock out the user as well as the IP
+	user, ip := strconv.Itoa(userID), clientIP(r)
+	if err := a.limits.ip.Check(ip); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := a.limits.twoFactor.Check(user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The password was checked under whichever name the user typed
+	email, nickname, err := userService.LoginNames(userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	token, err := userService.LoginTwoFactor(body.Token, body.Code, r.UserAgent(), ip)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCode) {
+			a.limits.twoFactor.Fail(user)
+			a.limits.ip.Fail(ip)
+		}
+		writeError(w, r, err)
+		return
+	}
+	a.limits.twoFactor.Reset(user)
+	a.limits.loginSucceeded(loginAccount(email))
+	a.limits.loginSucceeded(loginAccount(nickname))
+
+	a.setSessionCookie(w, token)
+	writeJSON(w, http.StatusOK, map[string]string{"token": token})
+}
+
+// SetupTwoFactorHandler starts enrolling the logged-in user, returning the
+// secret for their authenticator app.
+func (a *API) SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	twoFactorService := services.TwoFactorService{DB: a.DB}
+	setup, err := twoFactorService.Setup(currentUser(r).ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, setup)
+}
+
+// EnableTwoFactorHandler confirms enrollment with a code from the
+// authenticator and returns the user's recovery codes.
+func (a *API) EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	twoFactorService := services.TwoFactorService{DB: a.DB}
+	codes, err := twoFactorService.Enable(currentUser(r).ID, body.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"recoveryCodes": codes})
+}
+
+// DisableTwoFactorHandler turns two-factor login off after checking the
+// user's password.
+func (a *API) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	twoFactorService := services.TwoFactorService{DB: a.DB}
+	if err := twoFactorService.Disable(currentUser(r).ID, body.Password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeMessage(w, http.StatusOK, "Two-factor authentication turned off")
+}
@@ -2,20 +2,18 @@ package auth
 
 import (
 	"crypto/rand"
-	"database/sql"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"time"
 
-	"real-time-forum/internal/models"
-
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	SessionTokenLength = 32
-	SessionDuration    = 24 * time.Hour
+	// SessionRenewInterval limits how often a session's last-seen and
+	// expiry are pushed forward, so every request doesn't cost a write.
+	SessionRenewInterval = time.Minute
 )
 
 func HashPassword(password string) (string, error) {
@@ -38,28 +36,3 @@ func GenerateSessionToken() (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(token), nil
 }
-
-func ValidateSession(db *sql.DB, token string) (*models.User, error) {
-	if token == "" {
-		return nil, errors.New("empty session token")
-	}
-
-	query := `SELECT id, first_name, last_name, email, gender, age, nickname 
-          FROM users WHERE session_token = ?`
-
-	row := db.QueryRow(query, token)
-	user := &models.User{}
-	err := row.Scan(
-		&user.ID,
-		&user.FirstName,
-		&user.LastName,
-		&user.Email,
-		&user.Gender,
-		&user.Age,
-		&user.Nickname,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("invalid session: %w", err)
-	}
-	return user, nil
-}
@@ -0,0 +1,49 @@
+package auth
+
+// Roles a user can have. Every new account is a member.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Permission is something a role may be allowed to do.
+type Permission string
+
+const (
+	PermCreatePost       Permission = "create_post"
+	PermComment          Permission = "comment"
+	PermSendMessage      Permission = "send_message"
+	PermDeleteAnyPost    Permission = "delete_any_post"
+	PermBanUser          Permission = "ban_user"
+	PermManageCategories Permission = "manage_categories"
+	PermManageRoles      Permission = "manage_roles"
+)
+
+var memberPermissions = []Permission{PermCreatePost, PermComment, PermSendMessage}
+
+var moderatorPermissions = append([]Permission{PermDeleteAnyPost, PermBanUser}, memberPermissions...)
+
+// rolePermissions is what each role may do. Each role can do everything the
+// one below it can.
+var rolePermissions = map[string][]Permission{
+	RoleMember:    memberPermissions,
+	RoleModerator: moderatorPermissions,
+	RoleAdmin:     append([]Permission{PermManageCategories, PermManageRoles}, moderatorPermissions...),
+}
+
+// Can reports whether role grants perm. Unknown roles grant nothing.
+func Can(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole reports whether role is one of the defined roles.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
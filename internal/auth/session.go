@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"real-time-forum/internal/models"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// HashToken returns the form of a session token stored in the database.
+// Raw tokens only ever live in the client's cookie.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a new session for the user that lasts duration
+// without activity, and returns its raw token. Existing sessions on other
+// devices are left untouched.
+func CreateSession(db *sql.DB, userID int, userAgent, ip string, duration time.Duration) (string, error) {
+	token, err := GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	stmt := `INSERT INTO sessions (user_id, token_hash, user_agent, ip, created_at, last_seen_at, expires_at)
+	         VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err = db.Exec(stmt,
+		userID,
+		HashToken(token),
+		userAgent,
+		ip,
+		now.Format(time.RFC3339),
+		now.Format(time.RFC3339),
+		now.Add(duration).Format(time.RFC3339),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return token, nil
+}
+
+// LookupSession resolves a raw token to its session and user. Expired
+// sessions are deleted and rejected as not found, as are banned users'.
+func LookupSession(db *sql.DB, token string) (*models.Session, *models.User, error) {
+	if token == "" {
+		return nil, nil, fmt.Errorf("invalid session: empty token: %w", ErrSessionNotFound)
+	}
+
+	query := `SELECT s.id, s.user_id, s.user_agent, s.ip, s.created_at, s.last_seen_at, s.expires_at,
+	                 u.id, u.first_name, u.last_name, u.email, u.gender, u.age, u.nickname, u.email_verified_at,
+	                 u.role, u.totp_enabled_at IS NOT NULL
+	          FROM sessions s
+	          JOIN users u ON u.id = s.user_id
+	          WHERE s.token_hash = ? AND u.banned_at IS NULL`
+
+	session := &models.Session{}
+	user := &models.User{}
+	err := db.QueryRow(query, HashToken(token)).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Gender,
+		&user.Age,
+		&user.Nickname,
+		&user.EmailVerifiedAt,
+		&user.Role,
+		&user.TwoFactorEnabled,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("invalid session: %w", ErrSessionNotFound)
+		}
+		return nil, nil, fmt.Errorf("invalid session: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if !now.Before(session.ExpiresAt) {
+		if _, err := db.Exec("DELETE FROM sessions WHERE id = ?", session.ID); err != nil {
+			return nil, nil, fmt.Errorf("failed to delete expired session: %w", err)
+		}
+		return nil, nil, fmt.Errorf("invalid session: expired: %w", ErrSessionNotFound)
+	}
+
+	return session, user, nil
+}
+
+// TouchSession records activity on the session, sliding its expiry to
+// duration from now. It writes at most once per SessionRenewInterval.
+func TouchSession(db *sql.DB, session *models.Session, duration time.Duration) error {
+	now := time.Now().UTC()
+	if now.Sub(session.LastSeenAt) < SessionRenewInterval {
+		return nil
+	}
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(duration)
+	_, err := db.Exec("UPDATE sessions SET last_seen_at = ?, expires_at = ? WHERE id = ?",
+		session.LastSeenAt.Format(time.RFC3339),
+		session.ExpiresAt.Format(time.RFC3339),
+		session.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to renew session: %w", err)
+	}
+	return nil
+}
+
+func ValidateSession(db *sql.DB, token string) (*models.User, error) {
+	_, user, err := LookupSession(db, token)
+	return user, err
+}
+
+// DeleteSession ends the session identified by its raw token.
+func DeleteSession(db *sql.DB, token string) error {
+	if _, err := db.Exec("DELETE FROM sessions WHERE token_hash = ?", HashToken(token)); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// ListSessions returns the user's unexpired sessions, most recently used first.
+func ListSessions(db *sql.DB, userID int) ([]models.Session, error) {
+	query := `SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+	          FROM sessions
+	          WHERE user_id = ? AND expires_at > ?
+	          ORDER BY last_seen_at DESC`
+
+	rows, err := db.Query(query, userID, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession deletes one of the user's sessions. Sessions belonging to
+// other users are reported as not found.
+func RevokeSession(db *sql.DB, userID, sessionID int) error {
+	res, err := db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions deletes every session the user has.
+func RevokeAllSessions(db *sql.DB, userID int) error {
+	if _, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,505 @@
+// Package config loads the server configuration. Every setting has a
+// default and can be overridden, in increasing order of precedence, by the
+// config file, an environment variable and a command-line flag:
+//
+//	file key            environment variable     flag
+//	server.addr         FORUM_SERVER_ADDR        -server-addr
+//	database.path       FORUM_DATABASE_PATH      -database-path
+//	session.duration    FORUM_SESSION_DURATION   -session-duration
+//
+// and so on for every key listed in forum.example.toml. Secrets can't be
+// passed as flags, where they would show up in the process list. The
+// config file is forum.toml in the working directory if it exists, or the
+// file named by -config or FORUM_CONFIG.
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/internal/ratelimit"
+)
+
+const DefaultFile = "forum.toml"
+
+type Config struct {
+	Server    ServerConfig
+	Database  DatabaseConfig
+	Static    StaticConfig
+	Session   SessionConfig
+	Access    AccessConfig
+	Security  SecurityConfig
+	RateLimit RateLimitConfig
+	Mail      MailConfig
+	WebSocket WebSocketConfig
+}
+
+type ServerConfig struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout bounds how long in-flight requests get to finish
+	// after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration
+}
+
+type DatabaseConfig struct {
+	Path string
+}
+
+type StaticConfig struct {
+	// Dir, when set, serves the front end from disk instead of the copy
+	// embedded in the binary, re-reading files on every request.
+	Dir string
+}
+
+type SessionConfig struct {
+	Duration time.Duration
+	// CookieSecure marks the session cookie Secure. Browsers still send
+	// Secure cookies to http://localhost, so it only needs turning off to
+	// serve plain HTTP on another host.
+	CookieSecure bool
+}
+
+type AccessConfig struct {
+	// PublicContent lets visitors who aren't logged in read posts,
+	// comments, categories and search results. Off by default: the forum
+	// is for members only.
+	PublicContent bool
+}
+
+type SecurityConfig struct {
+	// Secret keys server-side MACs. When unset a random one is generated at
+	// startup, so anything signed with it doesn't survive a restart.
+	Secret string
+}
+
+type RateLimitConfig struct {
+	Enabled bool
+	// API limits every API request per client IP; Login and Register
+	// further limit those routes per IP.
+	API      ratelimit.Limit
+	Login    ratelimit.Limit
+	Register ratelimit.Limit
+	// LoginFailures failed logins in a row lock an account, or an IP at
+	// four times as many, out for LockoutBase, doubling with each further
+	// failure up to LockoutMax.
+	LoginFailures int
+	LockoutBase   time.Duration
+	LockoutMax    time.Duration
+	// Mail limits verification and password reset emails per client IP.
+	Mail ratelimit.Limit
+	// WebSocket limits the frames each user sends over all their
+	// connections.
+	WebSocket ratelimit.Limit
+}
+
+type MailConfig struct {
+	// Backend is "smtp" to deliver mail or "log" to log it, or write it to
+	// Dir, for development.
+	Backend string
+	From    string
+	Dir     string
+	// BaseURL is the forum's public address, which links in emails point
+	// to.
+	BaseURL      string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+type WebSocketConfig struct {
+	// AllowedOrigins lists origins, e.g. "https://forum.example", that may
+	// open a websocket besides the forum's own.
+	AllowedOrigins  []string
+	ReadBufferSize  int
+	WriteBufferSize int
+	// MaxMessageSize is the largest inbound frame accepted, in bytes.
+	// Larger frames close the connection with 1009 (message too big).
+	MaxMessageSize int64
+	// WriteWait bounds each write, including pings and close frames.
+	WriteWait time.Duration
+	// PongWait is how long a connection may stay silent before it is
+	// considered dead. Pings are sent every PingPeriod, which must be
+	// shorter, and any pong or message extends the deadline.
+	PongWait   time.Duration
+	PingPeriod time.Duration
+	// SendBuffer is how many outbound messages may queue before the client
+	// is dropped as too slow.
+	SendBuffer int
+}
+
+// MinSecretLength is the shortest accepted security.secret, in bytes.
+const MinSecretLength = 32
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   15 * time.Second,
+		},
+		Database: DatabaseConfig{Path: "./database/forum.db"},
+		Session: SessionConfig{
+			Duration:     24 * time.Hour,
+			CookieSecure: true,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:       true,
+			API:           ratelimit.Limit{Burst: 300, Per: time.Minute},
+			Login:         ratelimit.Limit{Burst: 10, Per: time.Minute},
+			Register:      ratelimit.Limit{Burst: 5, Per: time.Hour},
+			LoginFailures: 5,
+			LockoutBase:   time.Minute,
+			LockoutMax:    time.Hour,
+			Mail:          ratelimit.Limit{Burst: 5, Per: time.Hour},
+			WebSocket:     ratelimit.Limit{Burst: 30, Per: 10 * time.Second},
+		},
+		Mail: MailConfig{
+			Backend: "log",
+			From:    "Real-Time Forum <forum@localhost>",
+			BaseURL: "http://localhost:8080",
+		},
+		WebSocket: WebSocketConfig{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			MaxMessageSize:  16 * 1024,
+			WriteWait:       10 * time.Second,
+			PongWait:        60 * time.Second,
+			PingPeriod:      54 * time.Second,
+			SendBuffer:      256,
+		},
+	}
+}
+
+// setting binds one config key to its field.
+type setting struct {
+	key    string
+	usage  string
+	secret bool
+	set    func(value string) error
+}
+
+func (s setting) env() string {
+	return "FORUM_" + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+func (s setting) flag() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "server.addr", usage: "listen address", set: stringVar(&c.Server.Addr)},
+		{key: "server.read_header_timeout", usage: "time allowed to read request headers", set: durationVar(&c.Server.ReadHeaderTimeout)},
+		{key: "server.read_timeout", usage: "time allowed to read a whole request", set: durationVar(&c.Server.ReadTimeout)},
+		{key: "server.write_timeout", usage: "time allowed to write a response", set: durationVar(&c.Server.WriteTimeout)},
+		{key: "server.idle_timeout", usage: "how long idle keep-alive connections stay open", set: durationVar(&c.Server.IdleTimeout)},
+		{key: "server.shutdown_timeout", usage: "how long to wait for in-flight requests on shutdown", set: durationVar(&c.Server.ShutdownTimeout)},
+		{key: "database.path", usage: "SQLite database file", set: stringVar(&c.Database.Path)},
+		{key: "static.dir", usage: "serve the front end from this directory instead of the embedded copy (for development)", set: stringVar(&c.Static.Dir)},
+		{key: "session.duration", usage: "how long a session lasts without activity", set: durationVar(&c.Session.Duration)},
+		{key: "session.cookie_secure", usage: "mark the session cookie Secure", set: boolVar(&c.Session.CookieSecure)},
+		{key: "access.public_content", usage: "let visitors read posts without logging in", set: boolVar(&c.Access.PublicContent)},
+		{key: "security.secret", usage: "secret key for server-side MACs", secret: true, set: stringVar(&c.Security.Secret)},
+		{key: "rate_limit.enabled", usage: "rate limit requests and lock out repeated failed logins", set: boolVar(&c.RateLimit.Enabled)},
+		{key: "rate_limit.api", usage: "API requests per client IP, as burst/period", set: limitVar(&c.RateLimit.API)},
+		{key: "rate_limit.login", usage: "login attempts per client IP, as burst/period", set: limitVar(&c.RateLimit.Login)},
+		{key: "rate_limit.register", usage: "registrations per client IP, as burst/period", set: limitVar(&c.RateLimit.Register)},
+		{key: "rate_limit.login_failures", usage: "failed logins in a row before an account is locked out", set: intVar(&c.RateLimit.LoginFailures)},
+		{key: "rate_limit.lockout_base", usage: "first lockout after too many failed logins", set: durationVar(&c.RateLimit.LockoutBase)},
+		{key: "rate_limit.lockout_max", usage: "longest lockout after too many failed logins", set: durationVar(&c.RateLimit.LockoutMax)},
+		{key: "rate_limit.mail", usage: "verification and reset emails per client IP, as burst/period", set: limitVar(&c.RateLimit.Mail)},
+		{key: "rate_limit.websocket", usage: "websocket frames per user, as burst/period", set: limitVar(&c.RateLimit.WebSocket)},
+		{key: "mail.backend", usage: `"smtp" to deliver mail, or "log" to log it (or write it to mail.dir)`, set: stringVar(&c.Mail.Backend)},
+		{key: "mail.from", usage: "sender address of the forum's emails", set: stringVar(&c.Mail.From)},
+		{key: "mail.dir", usage: "directory the log backend writes emails to instead of logging them", set: stringVar(&c.Mail.Dir)},
+		{key: "mail.base_url", usage: "public URL of the forum, for links in emails", set: stringVar(&c.Mail.BaseURL)},
+		{key: "mail.smtp_addr", usage: "SMTP server host:port", set: stringVar(&c.Mail.SMTPAddr)},
+		{key: "mail.smtp_username", usage: "SMTP username; empty skips authentication", set: stringVar(&c.Mail.SMTPUsername)},
+		{key: "mail.smtp_password", usage: "SMTP password", secret: true, set: stringVar(&c.Mail.SMTPPassword)},
+		{key: "websocket.allowed_origins", usage: "comma-separated origins besides the forum's own allowed to open a websocket", set: listVar(&c.WebSocket.AllowedOrigins)},
+		{key: "websocket.read_buffer_size", usage: "websocket read buffer in bytes", set: intVar(&c.WebSocket.ReadBufferSize)},
+		{key: "websocket.write_buffer_size", usage: "websocket write buffer in bytes", set: intVar(&c.WebSocket.WriteBufferSize)},
+		{key: "websocket.max_message_size", usage: "largest inbound websocket frame in bytes", set: int64Var(&c.WebSocket.MaxMessageSize)},
+		{key: "websocket.write_wait", usage: "time allowed for each websocket write", set: durationVar(&c.WebSocket.WriteWait)},
+		{key: "websocket.pong_wait", usage: "how long a silent websocket stays open", set: durationVar(&c.WebSocket.PongWait)},
+		{key: "websocket.ping_period", usage: "interval between websocket pings", set: durationVar(&c.WebSocket.PingPeriod)},
+		{key: "websocket.send_buffer", usage: "outbound messages queued per websocket before it is dropped", set: intVar(&c.WebSocket.SendBuffer)},
+	}
+}
+
+func stringVar(p *string) func(string) error {
+	return func(v string) error { *p = v; return nil }
+}
+
+func durationVar(p *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*p = d
+		return nil
+	}
+}
+
+func boolVar(p *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}
+}
+
+func intVar(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	}
+}
+
+func int64Var(p *int64) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	}
+}
+
+func limitVar(p *ratelimit.Limit) func(string) error {
+	return func(v string) error {
+		l, err := ratelimit.ParseLimit(v)
+		if err != nil {
+			return err
+		}
+		*p = l
+		return nil
+	}
+}
+
+func listVar(p *[]string) func(string) error {
+	return func(v string) error {
+		*p = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+		return nil
+	}
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the flags in args, then validates it. It returns the
+// arguments left after the flags.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	configPath := fs.String("config", "", "config file (default "+DefaultFile+" if present; env FORUM_CONFIG)")
+	flagValues := make(map[string]*string)
+	for _, s := range settings {
+		if !s.secret {
+			flagValues[s.flag()] = fs.String(s.flag(), "", fmt.Sprintf("%s (env %s)", s.usage, s.env()))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	path, required := *configPath, true
+	if path == "" {
+		path = os.Getenv("FORUM_CONFIG")
+	}
+	if path == "" {
+		path, required = DefaultFile, false
+	}
+	if err := cfg.loadFile(path, required, settings); err != nil {
+		return nil, nil, err
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := s.set(v); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env(), err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if flagErr == nil && !s.secret && s.flag() == f.Name {
+				if err := s.set(*flagValues[f.Name]); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// EnsureSecret fills in a random security.secret if none was configured.
+func (c *Config) EnsureSecret() error {
+	if c.Security.Secret != "" {
+		return nil
+	}
+	secret := make([]byte, MinSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate secret: %w", err)
+	}
+	c.Security.Secret = hex.EncodeToString(secret)
+	log.Println("security.secret is not set; using a random secret for this run")
+	return nil
+}
+
+func (c *Config) loadFile(path string, required bool, settings []setting) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	values, err := parseTOML(f, path)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		v, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		if err := s.set(v); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, s.key, err)
+		}
+		delete(values, s.key)
+	}
+	for key := range values {
+		return fmt.Errorf("%s: unknown setting %q", path, key)
+	}
+	return nil
+}
+
+// Validate reports the first setting that can't work.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		return fmt.Errorf("server.addr: %w", err)
+	}
+	// A slice, not a map, so the same config always reports the same key
+	for _, timeout := range []struct {
+		key string
+		d   time.Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"websocket.write_wait", c.WebSocket.WriteWait},
+		{"websocket.pong_wait", c.WebSocket.PongWait},
+		{"websocket.ping_period", c.WebSocket.PingPeriod},
+	} {
+		if timeout.d <= 0 {
+			return fmt.Errorf("%s must be positive", timeout.key)
+		}
+	}
+
+	if c.Database.Path == "" {
+		return errors.New("database.path is required")
+	}
+	if c.Static.Dir != "" {
+		if info, err := os.Stat(c.Static.Dir); err != nil {
+			return fmt.Errorf("static.dir: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("static.dir: %s is not a directory", c.Static.Dir)
+		}
+	}
+
+	if c.Session.Duration < time.Minute {
+		return errors.New("session.duration must be at least 1m")
+	}
+	if c.Security.Secret != "" && len(c.Security.Secret) < MinSecretLength {
+		return fmt.Errorf("security.secret must be at least %d bytes", MinSecretLength)
+	}
+
+	if c.RateLimit.LoginFailures < 0 {
+		return errors.New("rate_limit.login_failures must not be negative")
+	}
+	if c.RateLimit.LoginFailures > 0 && (c.RateLimit.LockoutBase <= 0 || c.RateLimit.LockoutMax < c.RateLimit.LockoutBase) {
+		return errors.New("rate_limit.lockout_base must be positive and no longer than rate_limit.lockout_max")
+	}
+
+	switch c.Mail.Backend {
+	case "log":
+	case "smtp":
+		if _, _, err := net.SplitHostPort(c.Mail.SMTPAddr); err != nil {
+			return fmt.Errorf("mail.smtp_addr: %w", err)
+		}
+	default:
+		return fmt.Errorf("mail.backend must be \"smtp\" or \"log\", not %q", c.Mail.Backend)
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		return fmt.Errorf("mail.from: %w", err)
+	}
+	if u, err := url.Parse(c.Mail.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("mail.base_url: %q is not a URL like https://forum.example", c.Mail.BaseURL)
+	}
+
+	for _, origin := range c.WebSocket.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("websocket.allowed_origins: %q is not an origin like https://host[:port]", origin)
+		}
+	}
+	if c.WebSocket.ReadBufferSize <= 0 || c.WebSocket.WriteBufferSize <= 0 {
+		return errors.New("websocket buffer sizes must be positive")
+	}
+	if c.WebSocket.MaxMessageSize < 1024 {
+		return errors.New("websocket.max_message_size must be at least 1024")
+	}
+	if c.WebSocket.PingPeriod >= c.WebSocket.PongWait {
+		return errors.New("websocket.ping_period must be shorter than websocket.pong_wait")
+	}
+	if c.WebSocket.SendBuffer <= 0 {
+		return errors.New("websocket.send_buffer must be positive")
+	}
+	return nil
+}
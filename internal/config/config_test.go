@@ -0,0 +1,21 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateReportsFirstTimeout(t *testing.T) {
+	c := Default()
+	c.Server.WriteTimeout = 0
+	c.WebSocket.PongWait = -time.Second
+	c.Server.ReadTimeout = 0
+
+	// Every run must name the same setting
+	for i := 0; i < 20; i++ {
+		err := c.Validate()
+		if err == nil || err.Error() != "server.read_timeout must be positive" {
+			t.Fatalf("Validate = %v, want server.read_timeout must be positive", err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML the config file needs: [section]
+// headers, key = value pairs, # comments, and values that are strings,
+// integers, booleans or single-line arrays of strings. It returns the raw
+// values keyed by "section.key"; arrays are joined with commas.
+func parseTOML(r io.Reader, name string) (map[string]string, error) {
+	values := make(map[string]string)
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", name, lineNo, fmt.Sprintf(format, args...))
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fail("unterminated section header")
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if !isBareKey(section) {
+				return nil, fail("invalid section name %q", section)
+			}
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fail("expected key = value")
+		}
+		key = strings.TrimSpace(key)
+		if !isBareKey(key) {
+			return nil, fail("invalid key %q", key)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		if _, dup := values[key]; dup {
+			return nil, fail("duplicate key %q", key)
+		}
+
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fail("%s: %v", key, err)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return values, nil
+}
+
+func parseValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case raw[0] == '"' || raw[0] == '\'':
+		s, rest, err := parseString(raw)
+		if err != nil {
+			return "", err
+		}
+		if rest != "" {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return s, nil
+	case raw[0] == '[':
+		return parseArray(raw)
+	case raw == "true" || raw == "false":
+		return raw, nil
+	default:
+		n := strings.ReplaceAll(raw, "_", "")
+		if _, err := strconv.ParseInt(n, 10, 64); err != nil {
+			return "", fmt.Errorf("unsupported value %s (quote strings)", raw)
+		}
+		return n, nil
+	}
+}
+
+// parseString parses a basic ("...") or literal ('...') string at the start
+// of raw and returns it with whatever follows the closing quote.
+func parseString(raw string) (string, string, error) {
+	quote := raw[0]
+	var b strings.Builder
+	for i := 1; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == quote:
+			return b.String(), strings.TrimSpace(raw[i+1:]), nil
+		case c == '\\' && quote == '"':
+			i++
+			if i == len(raw) {
+				return "", "", fmt.Errorf("unterminated string")
+			}
+			switch raw[i] {
+			case '"', '\\':
+				b.WriteByte(raw[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return "", "", fmt.Errorf("unsupported escape \\%c", raw[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string")
+}
+
+func parseArray(raw string) (string, error) {
+	rest := strings.TrimSpace(raw[1:])
+	var items []string
+	for {
+		if strings.HasPrefix(rest, "]") {
+			if tail := strings.TrimSpace(rest[1:]); tail != "" {
+				return "", fmt.Errorf("unexpected %q after array", tail)
+			}
+			return strings.Join(items, ","), nil
+		}
+		if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+			return "", fmt.Errorf("arrays may only hold strings on a single line")
+		}
+		item, after, err := parseString(rest)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, item)
+
+		rest = strings.TrimSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return "", fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
+
+// stripComment removes a trailing # comment that isn't inside a string.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]string{},
+		},
+		{
+			name:  "top-level key",
+			input: `addr = ":8080"`,
+			want:  map[string]string{"addr": ":8080"},
+		},
+		{
+			name: "sections",
+			input: `
+[server]
+addr = ":8080"
+
+[database]
+path = "forum.db"
+`,
+			want: map[string]string{"server.addr": ":8080", "database.path": "forum.db"},
+		},
+		{
+			name: "comments and blank lines",
+			input: `
+# a comment
+[server] # after a header
+addr = ":8080" # after a value
+	# indented
+`,
+			want: map[string]string{"server.addr": ":8080"},
+		},
+		{
+			name:  "hash inside basic string",
+			input: `from = "Forum #1 <a@b.c>" # comment`,
+			want:  map[string]string{"from": "Forum #1 <a@b.c>"},
+		},
+		{
+			name:  "hash inside literal string",
+			input: `dir = 'C:\mail#box'`,
+			want:  map[string]string{"dir": `C:\mail#box`},
+		},
+		{
+			name:  "escaped quote before hash",
+			input: `s = "say \"#hi\"" # comment`,
+			want:  map[string]string{"s": `say "#hi"`},
+		},
+		{
+			name:  "escapes",
+			input: `s = "a\\b\tc\nd"`,
+			want:  map[string]string{"s": "a\\b\tc\nd"},
+		},
+		{
+			name:  "literal string keeps backslashes",
+			input: `s = 'a\nb'`,
+			want:  map[string]string{"s": `a\nb`},
+		},
+		{
+			name:  "empty string",
+			input: `s = ""`,
+			want:  map[string]string{"s": ""},
+		},
+		{
+			name:  "integers",
+			input: "a = 42\nb = -3\nc = 1_000",
+			want:  map[string]string{"a": "42", "b": "-3", "c": "1000"},
+		},
+		{
+			name:  "booleans",
+			input: "a = true\nb = false",
+			want:  map[string]string{"a": "true", "b": "false"},
+		},
+		{
+			name:  "string array",
+			input: `origins = ["https://a.example", 'https://b.example']`,
+			want:  map[string]string{"origins": "https://a.example,https://b.example"},
+		},
+		{
+			name:  "array with trailing comma and spacing",
+			input: `origins = [ "a" , "b", ]`,
+			want:  map[string]string{"origins": "a,b"},
+		},
+		{
+			name:  "empty array",
+			input: `origins = []`,
+			want:  map[string]string{"origins": ""},
+		},
+		{
+			name:  "bare keys with dashes and underscores",
+			input: "[rate_limit]\nlogin-failures = 5",
+			want:  map[string]string{"rate_limit.login-failures": "5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(strings.NewReader(tt.input), "test.toml")
+			if err != nil {
+				t.Fatalf("parseTOML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTOML = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// want is a substring of the error, which includes the line number
+		want string
+	}{
+		{"unterminated header", "[server", "test.toml:1: unterminated section header"},
+		{"invalid section name", "[a.b]", `invalid section name "a.b"`},
+		{"no equals", "addr", "expected key = value"},
+		{"invalid key", `"addr" = 1`, "invalid key"},
+		{"missing value", "addr =", "missing value"},
+		{"duplicate key", "[s]\na = 1\na = 2", `test.toml:3: duplicate key "s.a"`},
+		{"unquoted string", "addr = localhost", "unsupported value localhost (quote strings)"},
+		{"float", "ratio = 1.5", "unsupported value"},
+		{"unterminated string", `addr = ":8080`, "unterminated string"},
+		{"unterminated escape", `s = "a\`, "unterminated string"},
+		{"unsupported escape", `s = "\u0041"`, `unsupported escape \u`},
+		{"text after string", `s = "a" "b"`, `unexpected "\"b\"" after string`},
+		{"non-string array", "ports = [1, 2]", "arrays may only hold strings"},
+		{"multi-line array", "origins = [\n\"a\"]", "arrays may only hold strings"},
+		{"missing comma", `origins = ["a" "b"]`, "expected , or ] in array"},
+		{"text after array", `origins = ["a"] x`, `unexpected "x" after array`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML(strings.NewReader(tt.input), "test.toml")
+			if err == nil {
+				t.Fatalf("parseTOML succeeded, want error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Package csrf issues and checks tokens that prove a state-changing request
+// came from the forum's own pages. A token is an HMAC of the session token,
+// so it needs no server-side storage, changes with every login and can't
+// be computed by another site, which can make the browser send the session
+// cookie but can neither read it nor read the token.
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+)
+
+// Header is the request header state-changing requests carry the token
+// in, and the response header /api/session returns it in.
+const Header = "X-CSRF-Token"
+
+type Protector struct {
+	key []byte
+}
+
+// New returns a Protector keyed with secret, normally security.secret.
+func New(secret string) *Protector {
+	return &Protector{key: []byte(secret)}
+}
+
+// Token returns the CSRF token for the session with the given raw token.
+func (p *Protector) Token(sessionToken string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte("csrf\x00"))
+	mac.Write([]byte(sessionToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Valid reports whether token is the CSRF token for sessionToken.
+func (p *Protector) Valid(sessionToken, token string) bool {
+	if token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(p.Token(sessionToken)))
+}
+
+// Safe reports whether method only reads, and so needs no token.
+func Safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_reactions_content;
+DROP INDEX IF EXISTS idx_reactions_user_content;
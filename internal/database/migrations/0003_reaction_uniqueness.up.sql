@@ -0,0 +1,11 @@
+-- Keep only the latest reaction per user and item before enforcing uniqueness
+DELETE FROM reactions
+WHERE id NOT IN (
+	SELECT MAX(id) FROM reactions GROUP BY user_id, content_type, content_id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reactions_user_content
+	ON reactions(user_id, content_type, content_id);
+
+CREATE INDEX IF NOT EXISTS idx_reactions_content
+	ON reactions(content_type, content_id, reaction_type);
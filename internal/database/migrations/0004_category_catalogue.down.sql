@@ -0,0 +1,16 @@
+CREATE TABLE categories_legacy (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL,
+	category TEXT NOT NULL,
+	FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
+
+INSERT INTO categories_legacy (post_id, category)
+SELECT pc.post_id, c.name
+FROM post_categories pc
+JOIN categories c ON c.id = pc.category_id;
+
+DROP TABLE post_categories;
+DROP TABLE categories;
+
+ALTER TABLE categories_legacy RENAME TO categories;
@@ -0,0 +1,39 @@
+-- Categories were free text stored per post; move them into a managed
+-- catalogue joined to posts through post_categories.
+ALTER TABLE categories RENAME TO categories_legacy;
+
+CREATE TABLE categories (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	slug TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+	description TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE post_categories (
+	post_id INTEGER NOT NULL,
+	category_id INTEGER NOT NULL,
+	PRIMARY KEY (post_id, category_id),
+	FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+	FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_post_categories_category ON post_categories(category_id);
+
+INSERT INTO categories (slug, name, description) VALUES
+	('general', 'General', 'Anything that does not fit another category'),
+	('technology', 'Technology', 'Software, hardware and the web'),
+	('gaming', 'Gaming', 'Video games, board games and tabletop');
+
+-- Keep categories already in use so existing posts stay categorised
+INSERT OR IGNORE INTO categories (slug, name)
+SELECT DISTINCT lower(replace(trim(category), ' ', '-')), trim(category)
+FROM categories_legacy
+WHERE trim(category) <> '';
+
+INSERT OR IGNORE INTO post_categories (post_id, category_id)
+SELECT l.post_id, c.id
+FROM categories_legacy l
+JOIN categories c ON c.slug = lower(replace(trim(l.category), ' ', '-'));
+
+DROP TABLE categories_legacy;
@@ -0,0 +1,11 @@
+-- Per-conversation read markers: everything user_id received from
+-- other_user_id up to last_read_message_id has been read.
+CREATE TABLE conversation_reads (
+	user_id INTEGER NOT NULL,
+	other_user_id INTEGER NOT NULL,
+	last_read_message_id INTEGER NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, other_user_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (other_user_id) REFERENCES users(id) ON DELETE CASCADE
+);
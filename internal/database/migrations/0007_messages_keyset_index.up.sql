@@ -0,0 +1,4 @@
+-- Serves keyset pagination of a conversation: each direction of the
+-- conversation is a range scan on (sender_id, receiver_id) ordered by id.
+CREATE INDEX IF NOT EXISTS idx_messages_conversation
+	ON messages(sender_id, receiver_id, id);
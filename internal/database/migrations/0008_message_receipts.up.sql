@@ -0,0 +1,12 @@
+-- Per-message receipts: delivered_at is set once the message reached one
+-- of the receiver's connections (or their history), read_at once they read it.
+ALTER TABLE messages ADD COLUMN delivered_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN read_at TIMESTAMP;
+
+-- Messages read before this migration, per the conversation read markers
+UPDATE messages
+SET read_at = created_at, delivered_at = created_at
+WHERE id <= COALESCE((
+	SELECT cr.last_read_message_id FROM conversation_reads cr
+	WHERE cr.user_id = messages.receiver_id AND cr.other_user_id = messages.sender_id
+), 0);
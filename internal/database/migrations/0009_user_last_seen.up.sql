@@ -0,0 +1,3 @@
+-- When the user's last websocket connection closed (or their latest one
+-- opened); NULL for users who have never connected.
+ALTER TABLE users ADD COLUMN last_seen_at TIMESTAMP;
@@ -0,0 +1,8 @@
+-- Emails and nicknames are unique regardless of case. This fails if
+-- existing accounts already differ only by case; merge or rename them
+-- first.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_nocase
+	ON users(email COLLATE NOCASE);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_nickname_nocase
+	ON users(nickname COLLATE NOCASE);
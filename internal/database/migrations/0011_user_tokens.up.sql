@@ -0,0 +1,17 @@
+-- Single-use tokens mailed to users, e.g. to verify their email or reset
+-- their password. Like sessions, only a hash of each token is stored.
+CREATE TABLE IF NOT EXISTS user_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	purpose TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_purpose ON user_tokens(user_id, purpose);
+
+-- NULL until the user follows the link in their verification email
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
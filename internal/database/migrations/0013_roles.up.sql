@@ -0,0 +1,5 @@
+-- Each user has one role deciding what they may do; see auth.Can. Banned
+-- users can't log in.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'member'
+	CHECK (role IN ('admin', 'moderator', 'member'));
+ALTER TABLE users ADD COLUMN banned_at TIMESTAMP;
@@ -2,88 +2,58 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"real-time-forum/internal/config"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// InitDB initializes the database and returns a connection
-func InitDB(filepath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", filepath)
+// InitDB initializes the database, applies pending migrations and returns
+// a connection
+func InitDB(cfg config.DatabaseConfig) (*sql.DB, error) {
+	db, err := Open(cfg.Path)
 	if err != nil {
-		return nil, fmt.Errorf("database open failed: %w", err)
+		return nil, err
+	}
+
+	if err := checkFTS5(db); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	if err := createTables(db); err != nil {
-		return nil, fmt.Errorf("table creation failed: %w", err)
+	if _, err := MigrateUp(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migration failed: %w", err)
 	}
 
 	log.Println("Database initialized successfully")
 	return db, nil
 }
 
-func createTables(db *sql.DB) error {
-	tables := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			first_name TEXT NOT NULL,
-			last_name TEXT NOT NULL,
-			email TEXT NOT NULL UNIQUE,
-			gender TEXT NOT NULL,
-			age INTEGER NOT NULL,
-			nickname TEXT NOT NULL UNIQUE,
-			password TEXT NOT NULL,
-			session_token TEXT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		);`,
-		`CREATE TABLE IF NOT EXISTS posts (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			title TEXT NOT NULL,
-			content TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);`,
-		`CREATE TABLE IF NOT EXISTS categories (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			post_id INTEGER NOT NULL,
-			category TEXT NOT NULL,
-			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
-		);`,
-		`CREATE TABLE IF NOT EXISTS comments (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			post_id INTEGER NOT NULL,
-			user_id INTEGER NOT NULL,
-			comment TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);`,
-		`CREATE TABLE IF NOT EXISTS reactions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			user_id INTEGER NOT NULL,
-			content_type TEXT NOT NULL CHECK (content_type IN ('post', 'comment')),
-			content_id INTEGER NOT NULL,
-			reaction_type TEXT NOT NULL CHECK (reaction_type IN ('like', 'dislike')),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);`,
-		`CREATE TABLE IF NOT EXISTS messages (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			sender_id INTEGER NOT NULL,
-			receiver_id INTEGER NOT NULL,
-			content TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
-			FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE
-		);`,
+// Open returns a connection without touching the schema
+func Open(filepath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", filepath)
+	if err != nil {
+		return nil, fmt.Errorf("database open failed: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database open failed: %w", err)
 	}
+	return db, nil
+}
 
-	for _, table := range tables {
-		if _, err := db.Exec(table); err != nil {
-			return fmt.Errorf("failed to create table: %w", err)
-		}
+// checkFTS5 fails early with a useful message when the sqlite3 driver was
+// compiled without the FTS5 module that the search migration needs.
+func checkFTS5(db *sql.DB) error {
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return fmt.Errorf("failed to check sqlite compile options: %w", err)
+	}
+	if !enabled {
+		return errors.New("sqlite was built without FTS5, which search needs; build with -tags sqlite_fts5 or run make")
 	}
 	return nil
 }
@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogMailer doesn't deliver mail. With Dir set it writes each message to a
+// .eml file there; otherwise it logs it, links and all.
+type LogMailer struct {
+	From string
+	Dir  string
+
+	mu   sync.Mutex
+	sent int
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+	if m.Dir == "" {
+		log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	m.mu.Lock()
+	m.sent++
+	name := fmt.Sprintf("%s-%03d-%s.eml", time.Now().UTC().Format("20060102T150405"), m.sent, fileSafe(msg.To))
+	m.mu.Unlock()
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.Dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	return nil
+}
+
+// fileSafe keeps an address usable as part of a file name.
+func fileSafe(addr string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' ||
+			('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, addr)
+}
@@ -1,43 +1,89 @@
 package models
 
 import (
-	"database/sql"
 	"time"
 )
 
 type User struct {
-	ID           int
-	FirstName    string
-	LastName     string
-	Email        string
-	Gender       string
-	Age          int
-	Nickname     string
-	Password     string
-	SessionToken sql.NullString
-	CreatedAt    time.Time
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Gender    string `json:"gender"`
+	Age       int    `json:"age"`
+	Nickname  string `json:"nickname"`
+	// Password is only ever set from a registration request; it is never
+	// loaded back, so it is never sent out.
+	Password  string    `json:"password,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	// EmailVerifiedAt is nil until the user confirms their email address.
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	// Role is admin, moderator or member; see auth.Can for what each may do.
+	Role string `json:"role"`
+	// TwoFactorEnabled is whether logging in also takes a TOTP code.
+	TwoFactorEnabled bool `json:"twoFactorEnabled"`
+}
+
+// UserPresence is a user as shown in the user list: whether they have a
+// live connection and, if not, when they were last seen.
+type UserPresence struct {
+	ID         int        `json:"id"`
+	Nickname   string     `json:"nickname"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+}
+
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"-"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
 }
 
 type Post struct {
-	ID        int
-	UserID    int
-	Title     string
-	Content   string
-	CreatedAt time.Time
+	ID           int        `json:"id"`
+	UserID       int        `json:"userId"`
+	Author       string     `json:"author"`
+	Title        string     `json:"title"`
+	Content      string     `json:"content"`
+	Categories   []Category `json:"categories"`
+	Likes        int        `json:"likes"`
+	Dislikes     int        `json:"dislikes"`
+	UserReaction string     `json:"userReaction"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// PostDetail is a single post with one page of its comments.
+type PostDetail struct {
+	Post
+	CommentCount int       `json:"commentCount"`
+	Comments     []Comment `json:"comments"`
+	Page         int       `json:"page"`
+	Limit        int       `json:"limit"`
 }
 
 type Category struct {
-	ID     int
-	PostID int
-	Name   string
+	ID          int    `json:"id"`
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	PostCount   int    `json:"postCount"`
 }
 
 type Comment struct {
-	ID        int
-	PostID    int
-	UserID    int
-	Content   string
-	CreatedAt time.Time
+	ID           int       `json:"id"`
+	PostID       int       `json:"postId"`
+	UserID       int       `json:"userId"`
+	Author       string    `json:"author"`
+	Content      string    `json:"content"`
+	Likes        int       `json:"likes"`
+	Dislikes     int       `json:"dislikes"`
+	UserReaction string    `json:"userReaction"`
+	CreatedAt    time.Time `json:"createdAt"`
 }
 
 type Reaction struct {
@@ -49,12 +95,71 @@ type Reaction struct {
 	CreatedAt   time.Time
 }
 
+// ReactionSummary is the aggregate state of reactions on one post or comment.
+type ReactionSummary struct {
+	ContentType  string `json:"contentType"`
+	ContentID    int    `json:"contentId"`
+	Likes        int    `json:"likes"`
+	Dislikes     int    `json:"dislikes"`
+	UserReaction string `json:"userReaction,omitempty"`
+}
+
+// SearchResult is one hit from a full-text search. PostID and Title name
+// the post a comment belongs to; Snippet is HTML with matches in <mark>.
+type SearchResult struct {
+	Type      string    `json:"type"`
+	ID        int       `json:"id"`
+	PostID    int       `json:"postId,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	AuthorID  int       `json:"authorId"`
+	Author    string    `json:"author"`
+	Snippet   string    `json:"snippet"`
+	Rank      float64   `json:"rank"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
 type Message struct {
-	ID         int
-	SenderID   int
-	ReceiverID int
-	Content    string
-	CreatedAt  time.Time
+	ID          int        `json:"id"`
+	SenderID    int        `json:"senderId"`
+	ReceiverID  int        `json:"receiverId"`
+	Content     string     `json:"content"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
+	Status      string     `json:"status"`
+}
+
+// SetStatus derives Status ("sent", "delivered" or "read") from the
+// receipt timestamps.
+func (m *Message) SetStatus() {
+	switch {
+	case m.ReadAt != nil:
+		m.Status = "read"
+	case m.DeliveredAt != nil:
+		m.Status = "delivered"
+	default:
+		m.Status = "sent"
+	}
+}
+
+// MessagePage is one page of a conversation. NextCursor, when set, is the
+// message ID to pass as before (or after) to fetch the following page.
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor int       `json:"nextCursor,omitempty"`
+}
+
+// Conversation summarises a private chat from one user's point of view:
+// the other participant, the latest message and how many messages from
+// them the user hasn't read yet.
+type Conversation struct {
+	UserID        int       `json:"userId"`
+	Nickname      string    `json:"nickname"`
+	LastMessageID int       `json:"lastMessageId"`
+	LastSenderID  int       `json:"lastSenderId"`
+	LastMessage   string    `json:"lastMessage"`
+	LastMessageAt time.Time `json:"lastMessageAt"`
+	UnreadCount   int       `json:"unreadCount"`
 }
 
 type WebSocketMessage struct {
@@ -63,8 +168,19 @@ type WebSocketMessage struct {
 }
 
 type ChatMessage struct {
-	Sender    string `json:"sender"`
-	Receiver  string `json:"receiver"`
-	Content   string `json:"content"`
-	Timestamp string `json:"timestamp"`
+	ID         int    `json:"id"`
+	SenderID   int    `json:"senderId"`
+	ReceiverID int    `json:"receiverId"`
+	Sender     string `json:"sender"`
+	Content    string `json:"content"`
+	Timestamp  string `json:"timestamp"`
+	Status     string `json:"status"`
+}
+
+// ReadReceipt tells a sender that ReaderID has read every message they
+// sent them up to and including MessageID.
+type ReadReceipt struct {
+	ReaderID  int    `json:"readerId"`
+	MessageID int    `json:"messageId"`
+	ReadAt    string `json:"readAt"`
 }
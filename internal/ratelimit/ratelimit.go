@@ -0,0 +1,177 @@
+// Package ratelimit throttles clients with token buckets and locks out
+// keys, such as an account being guessed at, after repeated failures. State
+// lives in a Store: MemoryStore keeps it in the process, and another
+// implementation can share it between servers.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Burst events at once, refilling at Burst per Per. The zero
+// Limit allows everything.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseLimit parses a limit written as "burst/period", e.g. "5/1m".
+func ParseLimit(s string) (Limit, error) {
+	burst, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%q is not a limit like 10/1m", s)
+	}
+	var l Limit
+	var err error
+	if l.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || l.Burst <= 0 {
+		return Limit{}, fmt.Errorf("%q: burst must be a positive number", s)
+	}
+	if l.Per, err = time.ParseDuration(strings.TrimSpace(per)); err != nil || l.Per <= 0 {
+		return Limit{}, fmt.Errorf("%q: period must be a positive duration", s)
+	}
+	return l, nil
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// unlimited reports whether l lets everything through.
+func (l Limit) unlimited() bool {
+	return l.Burst <= 0 || l.Per <= 0
+}
+
+// interval is how long one token takes to refill.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Burst)
+}
+
+// Error is returned for a request that was refused. RetryAfter is how long
+// until the same request could succeed.
+type Error struct {
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("too many requests; retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// Seconds is RetryAfter rounded up to whole seconds, as sent in a
+// Retry-After header.
+func (e *Error) Seconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// Limiter applies one Limit to many keys, such as client IPs.
+type Limiter struct {
+	store Store
+	name  string
+	limit Limit
+	now   func() time.Time
+}
+
+// NewLimiter returns a limiter that keeps its buckets in store under name,
+// which must be unique among the limiters sharing store.
+func NewLimiter(store Store, name string, limit Limit) *Limiter {
+	return &Limiter{store: store, name: name, limit: limit, now: time.Now}
+}
+
+// Allow takes a token from key's bucket. It returns an *Error if the bucket
+// is empty. A nil Limiter allows everything.
+func (l *Limiter) Allow(key string) error {
+	if l == nil || l.limit.unlimited() {
+		return nil
+	}
+
+	now := l.now()
+	interval := l.limit.interval()
+	var wait time.Duration
+	l.store.Update(l.name+":"+key, func(e *Entry) {
+		// The bucket is full again at Full; each token taken pushes that
+		// one interval further out, and a bucket can't owe more than Per
+		full := e.Full
+		if full.Before(now) {
+			full = now
+		}
+		next := full.Add(interval)
+		if over := next.Sub(now) - l.limit.Per; over > 0 {
+			wait = over
+			return
+		}
+		e.Full = next
+		e.Expires = next
+	})
+	if wait > 0 {
+		return &Error{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Lockout locks a key out after a threshold of consecutive failures, first
+// for a base duration and then twice as long for each further failure, up
+// to a maximum. A key's failures are forgotten after the maximum passes
+// without one.
+type Lockout struct {
+	store     Store
+	name      string
+	threshold int
+	base, max time.Duration
+	now       func() time.Time
+}
+
+// NewLockout returns a lockout that keeps its state in store under name. A
+// threshold of zero disables it.
+func NewLockout(store Store, name string, threshold int, base, max time.Duration) *Lockout {
+	return &Lockout{store: store, name: name, threshold: threshold, base: base, max: max, now: time.Now}
+}
+
+// Check returns an *Error if key is locked out. A nil Lockout never locks.
+func (l *Lockout) Check(key string) error {
+	if l == nil || l.threshold <= 0 {
+		return nil
+	}
+	now := l.now()
+	var wait time.Duration
+	l.store.Update(l.name+":"+key, func(e *Entry) {
+		wait = e.LockedUntil.Sub(now)
+	})
+	if wait > 0 {
+		return &Error{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Fail records a failure for key, locking it out if it has now failed
+// threshold times in a row.
+func (l *Lockout) Fail(key string) {
+	if l == nil || l.threshold <= 0 {
+		return
+	}
+	now := l.now()
+	l.store.Update(l.name+":"+key, func(e *Entry) {
+		e.Failures++
+		if over := e.Failures - l.threshold; over >= 0 {
+			lock := l.max
+			if over < 32 && l.base<<over < l.max {
+				lock = l.base << over
+			}
+			e.LockedUntil = now.Add(lock)
+		}
+		e.Expires = now.Add(l.max)
+		if e.LockedUntil.After(now) {
+			e.Expires = e.LockedUntil.Add(l.max)
+		}
+	})
+}
+
+// Reset clears key's failures, e.g. after a successful login.
+func (l *Lockout) Reset(key string) {
+	if l == nil || l.threshold <= 0 {
+		return
+	}
+	l.store.Delete(l.name + ":" + key)
+}
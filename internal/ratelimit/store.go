@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry is the state kept for one key. Limiters use Full and lockouts use
+// Failures and LockedUntil.
+type Entry struct {
+	// Full is when the key's token bucket will be full again.
+	Full        time.Time
+	Failures    int
+	LockedUntil time.Time
+	// Expires is when the entry stops mattering; a store may drop it any
+	// time after.
+	Expires time.Time
+}
+
+// Store holds limiter and lockout state by key.
+type Store interface {
+	// Update calls fn with key's entry, a zero Entry if there is none, and
+	// saves what fn leaves in it. Updates of one key must not interleave.
+	Update(key string, fn func(*Entry))
+	Delete(key string)
+}
+
+// sweepInterval is how often MemoryStore drops expired entries.
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store in the process's memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*Entry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[string]*Entry),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (s *MemoryStore) Update(key string, fn func(*Entry)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &Entry{}
+	}
+	fn(entry)
+	if entry.Expires.After(now) {
+		s.entries[key] = entry
+	} else {
+		delete(s.entries, key)
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// sweep drops expired entries so idle keys don't accumulate. s.mu must be
+// held.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, entry := range s.entries {
+		if !entry.Expires.After(now) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"real-time-forum/internal/auth"
+	"real-time-forum/internal/mail"
+	"real-time-forum/internal/models"
+)
+
+// How long emailed links stay valid
+const (
+	VerifyEmailTTL   = 24 * time.Hour
+	ResetPasswordTTL = time.Hour
+)
+
+// AccountService handles account recovery and email verification, which
+// work through links mailed to the user.
+type AccountService struct {
+	DB     *sql.DB
+	Mailer mail.Mailer
+	// BaseURL is the forum's public address, for links in emails.
+	BaseURL string
+}
+
+// SendVerification mails the user a link to confirm their email address.
+func (s *AccountService) SendVerification(userID int) error {
+	var email, nickname string
+	var verifiedAt *time.Time
+	err := s.DB.QueryRow("SELECT email, nickname, email_verified_at FROM users WHERE id = ?", userID).
+		Scan(&email, &nickname, &verifiedAt)
+	if err == sql.ErrNoRows {
+		return NotFoundError("user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if verifiedAt != nil {
+		return ConflictError("your email address is already verified")
+	}
+
+	token, err := issueToken(s.DB, userID, PurposeVerifyEmail, VerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address for Real-Time Forum by opening this link:\n\n%s\n\n"+
+			"The link expires in 24 hours. If you didn't sign up, ignore this email.\n",
+			nickname, s.link("/verify-email", token)),
+	})
+}
+
+// VerifyEmail consumes a verification token and marks the address verified.
+func (s *AccountService) VerifyEmail(token string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeToken(tx, PurposeVerifyEmail, token)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339), userID); err != nil {
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RequestPasswordReset mails a reset link to the account registered with
+// email. It reports success whether or not there is such an account, so
+// the response doesn't reveal who is registered.
+func (s *AccountService) RequestPasswordReset(email string) error {
+	var userID int
+	var nickname string
+	err := s.DB.QueryRow("SELECT id, nickname, email FROM users WHERE email = ? COLLATE NOCASE", email).
+		Scan(&userID, &nickname, &email)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	token, err := issueToken(s.DB, userID, PurposeResetPassword, ResetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your Real-Time Forum account. "+
+			"To choose a new one, open this link:\n\n%s\n\n"+
+			"The link expires in an hour. If it wasn't you, ignore this email; your password hasn't changed.\n",
+			nickname, s.link("/reset-password", token)),
+	})
+}
+
+// ResetPassword consumes a reset token and sets the user's new password.
+// Every session the user had is revoked, logging out whoever might have
+// been using the old password. It returns the user's ID.
+func (s *AccountService) ResetPassword(token, password string) (int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeToken(tx, PurposeResetPassword, token)
+	if err != nil {
+		return 0, err
+	}
+
+	user := models.User{Password: password}
+	if err := tx.QueryRow("SELECT nickname, email FROM users WHERE id = ?", userID).
+		Scan(&user.Nickname, &user.Email); err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	if msg := passwordProblem(&user); msg != "" {
+		return 0, FieldErrors(map[string]string{"password": msg})
+	}
+	hashed, err := auth.HashPassword(password)
+	if err != nil {
+		return 0, fmt.Errorf("password hashing failed: %w", err)
+	}
+
+	if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", hashed, userID); err != nil {
+		return 0, fmt.Errorf("failed to update password: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	// Receiving the reset link proves the user reads that inbox
+	if _, err := tx.Exec("UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339), userID); err != nil {
+		return 0, fmt.Errorf("failed to verify email: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit password reset: %w", err)
+	}
+	return userID, nil
+}
+
+// link is the front-end URL at path carrying token.
+func (s *AccountService) link(path, token string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
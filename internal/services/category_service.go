@@ -0,0 +1,161 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"real-time-forum/internal/models"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrCategoryNotFound = NotFoundError("category not found")
+	ErrUnknownCategory  = ValidationError("unknown category")
+	ErrCategoryExists   = ConflictError("category already exists")
+)
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a category name into its URL-safe slug, e.g.
+// "Board Games!" becomes "board-games".
+func Slugify(name string) string {
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+type CategoryService struct {
+	DB *sql.DB
+}
+
+// ListCategories returns the whole catalogue with the number of posts in
+// each category, sorted by name.
+func (s *CategoryService) ListCategories() ([]models.Category, error) {
+	query := `SELECT c.id, c.slug, c.name, c.description, COUNT(pc.post_id)
+	          FROM categories c
+	          LEFT JOIN post_categories pc ON pc.category_id = c.id
+	          GROUP BY c.id
+	          ORDER BY c.name`
+
+	rows, err := s.DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var category models.Category
+		if err := rows.Scan(
+			&category.ID,
+			&category.Slug,
+			&category.Name,
+			&category.Description,
+			&category.PostCount,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// GetCategory looks a category up by slug.
+func (s *CategoryService) GetCategory(slug string) (*models.Category, error) {
+	var category models.Category
+	err := s.DB.QueryRow("SELECT id, slug, name, description FROM categories WHERE slug = ?", slug).Scan(
+		&category.ID,
+		&category.Slug,
+		&category.Name,
+		&category.Description,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return &category, nil
+}
+
+// CreateCategory adds a category to the catalogue, deriving its slug from
+// the name.
+func (s *CategoryService) CreateCategory(name, description string) (*models.Category, error) {
+	name = strings.TrimSpace(name)
+	category := &models.Category{
+		Slug:        Slugify(name),
+		Name:        name,
+		Description: strings.TrimSpace(description),
+	}
+	if category.Slug == "" {
+		return nil, FieldErrors(map[string]string{"name": "category name is required"})
+	}
+
+	// Slugs and names are unique, so a clash is caught by the insert itself
+	res, err := s.DB.Exec("INSERT INTO categories (slug, name, description) VALUES (?, ?, ?)",
+		category.Slug, category.Name, category.Description)
+	if _, ok := uniqueViolation(err); ok {
+		return nil, ErrCategoryExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("category creation failed: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category ID: %w", err)
+	}
+	category.ID = int(id)
+	return category, nil
+}
+
+// DeleteCategory removes a category from the catalogue. Its posts stay,
+// without it.
+func (s *CategoryService) DeleteCategory(slug string) error {
+	category, err := s.GetCategory(slug)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("transaction start failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE category_id = ?", category.ID); err != nil {
+		return fmt.Errorf("category deletion failed: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", category.ID); err != nil {
+		return fmt.Errorf("category deletion failed: %w", err)
+	}
+	return tx.Commit()
+}
+
+// resolveCategories maps slugs to category IDs, failing with
+// ErrUnknownCategory on the first slug missing from the catalogue.
+// Duplicate slugs are collapsed.
+func resolveCategories(tx *sql.Tx, slugs []string) ([]int, error) {
+	seen := make(map[string]bool)
+	var ids []int
+	for _, slug := range slugs {
+		slug = strings.TrimSpace(slug)
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+
+		var id int
+		err := tx.QueryRow("SELECT id FROM categories WHERE slug = ?", slug).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, &Error{
+				Kind:    KindValidation,
+				Message: fmt.Sprintf("unknown category %q", slug),
+				Fields:  map[string]string{"categories": fmt.Sprintf("unknown category %q", slug)},
+				Err:     ErrUnknownCategory,
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
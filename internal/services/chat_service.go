@@ -4,55 +4,291 @@ import (
 	"database/sql"
 	"fmt"
 	"real-time-forum/internal/models"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type ChatService struct {
 	DB *sql.DB
 }
 
-func (s *ChatService) SaveMessage(senderID, receiverID int, content string) error {
+var ErrMessageNotFound = NotFoundError("message not found")
+
+// MaxMessageLength is the longest private message, in characters, that
+// SaveMessage accepts.
+const MaxMessageLength = 2000
+
+// SaveMessage validates and stores a private message, returning it with its
+// server-assigned ID and timestamp.
+func (s *ChatService) SaveMessage(senderID, receiverID int, content string) (*models.Message, error) {
+	content = strings.TrimSpace(content)
 	if content == "" {
-		return fmt.Errorf("message content cannot be empty")
+		return nil, FieldErrors(map[string]string{"content": "message cannot be empty"})
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return nil, FieldErrors(map[string]string{
+			"content": fmt.Sprintf("message cannot be longer than %d characters", MaxMessageLength),
+		})
+	}
+	if receiverID == senderID {
+		return nil, FieldErrors(map[string]string{"receiverId": "cannot send a message to yourself"})
+	}
+
+	var exists bool
+	if err := s.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", receiverID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if !exists {
+		return nil, NotFoundError("receiver does not exist")
+	}
+
+	msg := &models.Message{
+		SenderID:   senderID,
+		ReceiverID: receiverID,
+		Content:    content,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+		Status:     "sent",
 	}
 
-	stmt := `INSERT INTO messages (sender_id, receiver_id, content, created_at) 
+	stmt := `INSERT INTO messages (sender_id, receiver_id, content, created_at)
 	         VALUES (?, ?, ?, ?)`
-	
-	_, err := s.DB.Exec(stmt, senderID, receiverID, content, time.Now().Format(time.RFC3339))
+
+	res, err := s.DB.Exec(stmt, senderID, receiverID, content, msg.CreatedAt.Format(time.RFC3339))
 	if err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+		return nil, fmt.Errorf("failed to save message: %w", err)
 	}
-	return nil
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message ID: %w", err)
+	}
+	msg.ID = int(id)
+	return msg, nil
 }
 
-func (s *ChatService) GetMessages(senderID, receiverID, limit, offset int) ([]models.Message, error) {
-	query := `SELECT id, sender_id, receiver_id, content, created_at 
-	          FROM messages 
-	          WHERE (sender_id = ? AND receiver_id = ?) 
-	             OR (sender_id = ? AND receiver_id = ?)
-	          ORDER BY created_at DESC 
-	          LIMIT ? OFFSET ?`
-	
-	rows, err := s.DB.Query(query, senderID, receiverID, receiverID, senderID, limit, offset)
+// GetMessages returns one page of the conversation between userID and
+// otherUserID using keyset pagination on message ID, so messages arriving
+// while a client scrolls never shift the pages.
+//
+// With before set, it returns the newest limit messages older than that ID;
+// with after set, the oldest limit messages newer than it; with neither, the
+// newest limit messages. Messages in a page are in chronological order.
+// NextCursor is set when more messages exist in the same direction.
+func (s *ChatService) GetMessages(userID, otherUserID, before, after, limit int) (*models.MessagePage, error) {
+	if before > 0 && after > 0 {
+		return nil, ValidationError("before and after cannot be combined")
+	}
+
+	where := `((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))`
+	args := []interface{}{userID, otherUserID, otherUserID, userID}
+	order := "DESC"
+	switch {
+	case before > 0:
+		where += " AND id < ?"
+		args = append(args, before)
+	case after > 0:
+		where += " AND id > ?"
+		args = append(args, after)
+		order = "ASC"
+	}
+
+	// Fetch one extra row to learn whether another page exists
+	query := `SELECT id, sender_id, receiver_id, content, created_at, delivered_at, read_at
+	          FROM messages
+	          WHERE ` + where + `
+	          ORDER BY id ` + order + `
+	          LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := s.DB.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("database error: %w", err)
 	}
 	defer rows.Close()
 
-	var messages []models.Message
+	messages := []models.Message{}
 	for rows.Next() {
 		var msg models.Message
+		var deliveredAt, readAt sql.NullTime
 		if err := rows.Scan(
 			&msg.ID,
 			&msg.SenderID,
 			&msg.ReceiverID,
 			&msg.Content,
 			&msg.CreatedAt,
+			&deliveredAt,
+			&readAt,
 		); err != nil {
 			return nil, fmt.Errorf("scan error: %w", err)
 		}
+		if deliveredAt.Valid {
+			msg.DeliveredAt = &deliveredAt.Time
+		}
+		if readAt.Valid {
+			msg.ReadAt = &readAt.Time
+		}
+		msg.SetStatus()
 		messages = append(messages, msg)
 	}
-	return messages, nil
-}
\ No newline at end of file
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	page := &models.MessagePage{}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		// The last row in query order is the far edge of this page
+		page.NextCursor = messages[limit-1].ID
+	}
+	if order == "DESC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	page.Messages = messages
+	return page, nil
+}
+
+// conversationQuery lists userID's conversations, one row per counterpart,
+// built around the latest message exchanged with each. Parameters: userID
+// five times, then the counterpart filter (0 for all) twice.
+const conversationQuery = `
+	WITH latest AS (
+		SELECT CASE WHEN sender_id = ? THEN receiver_id ELSE sender_id END AS other_id,
+		       MAX(id) AS last_id
+		FROM messages
+		WHERE sender_id = ? OR receiver_id = ?
+		GROUP BY other_id
+	)
+	SELECT l.other_id, u.nickname, m.id, m.sender_id, m.content, m.created_at,
+	       (SELECT COUNT(*) FROM messages um
+	        WHERE um.sender_id = l.other_id AND um.receiver_id = ?
+	          AND um.id > COALESCE(cr.last_read_message_id, 0))
+	FROM latest l
+	JOIN messages m ON m.id = l.last_id
+	JOIN users u ON u.id = l.other_id
+	LEFT JOIN conversation_reads cr ON cr.user_id = ? AND cr.other_user_id = l.other_id
+	WHERE ? = 0 OR l.other_id = ?
+	ORDER BY m.id DESC`
+
+// GetConversations returns everyone userID has exchanged messages with,
+// most recent conversation first, with the last message and unread count.
+func (s *ChatService) GetConversations(userID int) ([]models.Conversation, error) {
+	return s.queryConversations(userID, 0)
+}
+
+// GetConversation returns userID's conversation with otherUserID, or nil if
+// they have never exchanged messages.
+func (s *ChatService) GetConversation(userID, otherUserID int) (*models.Conversation, error) {
+	conversations, err := s.queryConversations(userID, otherUserID)
+	if err != nil || len(conversations) == 0 {
+		return nil, err
+	}
+	return &conversations[0], nil
+}
+
+func (s *ChatService) queryConversations(userID, otherUserID int) ([]models.Conversation, error) {
+	rows, err := s.DB.Query(conversationQuery, userID, userID, userID, userID, userID, otherUserID, otherUserID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []models.Conversation{}
+	for rows.Next() {
+		var c models.Conversation
+		if err := rows.Scan(
+			&c.UserID,
+			&c.Nickname,
+			&c.LastMessageID,
+			&c.LastSenderID,
+			&c.LastMessage,
+			&c.LastMessageAt,
+			&c.UnreadCount,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+// MarkDelivered records that a message reached one of its receiver's
+// connections.
+func (s *ChatService) MarkDelivered(messageID int) error {
+	_, err := s.DB.Exec("UPDATE messages SET delivered_at = ? WHERE id = ? AND delivered_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339), messageID)
+	if err != nil {
+		return fmt.Errorf("failed to mark message delivered: %w", err)
+	}
+	return nil
+}
+
+// MarkConversationRead records that userID has read everything otherUserID
+// sent them up to and including upToID, or the newest such message before
+// it; 0 means up to the latest message.
+// Each of those messages gets a read receipt and the conversation's read
+// marker moves forward (never backwards). It returns the ID the marker was
+// moved to, or 0 if there was nothing to read.
+func (s *ChatService) MarkConversationRead(userID, otherUserID, upToID int) (int, error) {
+	// Clamp to a message the other user actually sent, so a made-up ID
+	// can't move the marker past messages that haven't arrived yet
+	err := s.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM messages
+	                      WHERE sender_id = ? AND receiver_id = ? AND (? = 0 OR id <= ?)`,
+		otherUserID, userID, upToID, upToID).Scan(&upToID)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	if upToID == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("transaction start failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err = tx.Exec(`UPDATE messages
+	                  SET read_at = ?, delivered_at = COALESCE(delivered_at, ?)
+	                  WHERE sender_id = ? AND receiver_id = ? AND id <= ? AND read_at IS NULL`,
+		now, now, otherUserID, userID, upToID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark messages read: %w", err)
+	}
+
+	stmt := `INSERT INTO conversation_reads (user_id, other_user_id, last_read_message_id, updated_at)
+	         VALUES (?, ?, ?, ?)
+	         ON CONFLICT (user_id, other_user_id) DO UPDATE SET
+	             last_read_message_id = MAX(last_read_message_id, excluded.last_read_message_id),
+	             updated_at = excluded.updated_at`
+	if _, err := tx.Exec(stmt, userID, otherUserID, upToID, now); err != nil {
+		return 0, fmt.Errorf("failed to mark conversation read: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("transaction commit failed: %w", err)
+	}
+	return upToID, nil
+}
+
+// MarkMessageRead marks messageID, and everything before it in the same
+// conversation, as read by readerID, who must be its receiver. It returns
+// the message's sender.
+func (s *ChatService) MarkMessageRead(readerID, messageID int) (int, error) {
+	var senderID int
+	err := s.DB.QueryRow("SELECT sender_id FROM messages WHERE id = ? AND receiver_id = ?",
+		messageID, readerID).Scan(&senderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrMessageNotFound
+		}
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+
+	if _, err := s.MarkConversationRead(readerID, senderID, messageID); err != nil {
+		return 0, err
+	}
+	return senderID, nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Kind says whose fault an Error is and so how it is reported.
+type Kind int
+
+const (
+	// KindValidation means the request was malformed or failed a rule.
+	KindValidation Kind = iota + 1
+	KindNotFound
+	KindConflict
+	KindUnauthorized
+	// KindForbidden means the user is known but may not do this.
+	KindForbidden
+)
+
+var kindCodes = map[Kind]string{
+	KindValidation:   "validation_error",
+	KindNotFound:     "not_found",
+	KindConflict:     "conflict",
+	KindUnauthorized: "unauthorized",
+	KindForbidden:    "forbidden",
+}
+
+// Code is the machine-readable name clients see for the kind, e.g.
+// "not_found".
+func (k Kind) Code() string {
+	if code, ok := kindCodes[k]; ok {
+		return code
+	}
+	return "internal_error"
+}
+
+// Error is an error caused by the request rather than the server. Its
+// Message is safe to show to the user. Errors of any other type are
+// internal: they are logged, and the user only learns that something went
+// wrong.
+type Error struct {
+	Kind    Kind
+	Message string
+	// Fields maps request fields to what is wrong with each, for
+	// validation errors.
+	Fields map[string]string
+	// Err is the sentinel this error is a more specific instance of, if
+	// any, so errors.Is still matches.
+	Err error
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func ValidationError(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+// FieldErrors reports validation failures on individual request fields.
+func FieldErrors(fields map[string]string) *Error {
+	return &Error{Kind: KindValidation, Message: "some fields are invalid", Fields: fields}
+}
+
+func NotFoundError(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func ConflictError(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+func UnauthorizedError(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindUnauthorized, Message: fmt.Sprintf(format, args...)}
+}
+
+func ForbiddenError(format string, args ...interface{}) *Error {
+	return &Error{Kind: KindForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+// uniqueViolation reports whether err is SQLite rejecting a write for
+// breaking a unique index, and if so which columns, e.g. "users.email".
+func uniqueViolation(err error) (columns string, ok bool) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return "", false
+	}
+	_, columns, _ = strings.Cut(sqliteErr.Error(), "UNIQUE constraint failed: ")
+	return columns, true
+}
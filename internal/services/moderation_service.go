@@ -0,0 +1,122 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"real-time-forum/internal/auth"
+	"real-time-forum/internal/models"
+)
+
+var ErrPermissionDenied = ForbiddenError("you are not allowed to do that")
+
+// Authorize returns ErrPermissionDenied unless role grants perm. HTTP and
+// websocket handlers call it before acting for a user.
+func Authorize(role string, perm auth.Permission) error {
+	if !auth.Can(role, perm) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// ModerationService changes users' roles and bans.
+type ModerationService struct {
+	DB *sql.DB
+}
+
+// SetRole gives a user a new role.
+func (s *ModerationService) SetRole(userID int, role string) error {
+	if !auth.ValidRole(role) {
+		return FieldErrors(map[string]string{"role": "role must be admin, moderator or member"})
+	}
+	res, err := s.DB.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return NotFoundError("user not found")
+	}
+	return nil
+}
+
+// Ban stops a user logging in and ends their sessions. Moderators may only
+// ban members; admins may also ban moderators, and nobody can ban an
+// admin, who has to be demoted first.
+func (s *ModerationService) Ban(moderator *models.User, userID int) error {
+	if userID == moderator.ID {
+		return ValidationError("you can't ban yourself")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var role string
+	var bannedAt *time.Time
+	err = tx.QueryRow("SELECT role, banned_at FROM users WHERE id = ?", userID).Scan(&role, &bannedAt)
+	if err == sql.ErrNoRows {
+		return NotFoundError("user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := checkBanRank(moderator, role); err != nil {
+		return err
+	}
+	if bannedAt != nil {
+		return ConflictError("user is already banned")
+	}
+
+	if _, err := tx.Exec("UPDATE users SET banned_at = ? WHERE id = ?",
+		time.Now().UTC().Format(time.RFC3339), userID); err != nil {
+		return fmt.Errorf("failed to ban user: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	// Pending two-factor logins and reset links die with the sessions
+	if _, err := tx.Exec("DELETE FROM user_tokens WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Unban lets a banned user log in again. Whoever may ban the user may
+// unban them, so a moderator can't lift a ban on another moderator.
+func (s *ModerationService) Unban(moderator *models.User, userID int) error {
+	var role string
+	var bannedAt *time.Time
+	err := s.DB.QueryRow("SELECT role, banned_at FROM users WHERE id = ?", userID).Scan(&role, &bannedAt)
+	if err == sql.ErrNoRows {
+		return NotFoundError("user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if err := checkBanRank(moderator, role); err != nil {
+		return err
+	}
+	if bannedAt == nil {
+		return ConflictError("user is not banned")
+	}
+	if _, err := s.DB.Exec("UPDATE users SET banned_at = NULL WHERE id = ?", userID); err != nil {
+		return fmt.Errorf("failed to unban user: %w", err)
+	}
+	return nil
+}
+
+// checkBanRank returns a Forbidden error unless moderator outranks a user
+// with the given role: moderators outrank members, admins also outrank
+// moderators, and nobody outranks an admin.
+func checkBanRank(moderator *models.User, role string) error {
+	switch {
+	case role == auth.RoleAdmin:
+		return ForbiddenError("admins can't be banned")
+	case role == auth.RoleModerator && moderator.Role != auth.RoleAdmin:
+		return ForbiddenError("only admins can ban or unban moderators")
+	}
+	return nil
+}
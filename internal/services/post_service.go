@@ -2,19 +2,32 @@ package services
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
+	"real-time-forum/internal/auth"
 	"real-time-forum/internal/models"
+	"strings"
 	"time"
 )
 
+var ErrPostNotFound = NotFoundError("post not found")
+
 type PostService struct {
 	DB *sql.DB
 }
 
 func (s *PostService) CreatePost(userID int, title, content string, categories []string) error {
-	if title == "" || content == "" || len(categories) == 0 {
-		return errors.New("all fields are required")
+	fields := make(map[string]string)
+	if strings.TrimSpace(title) == "" {
+		fields["title"] = "title is required"
+	}
+	if strings.TrimSpace(content) == "" {
+		fields["content"] = "content is required"
+	}
+	if len(categories) == 0 {
+		fields["categories"] = "choose at least one category"
+	}
+	if len(fields) > 0 {
+		return FieldErrors(fields)
 	}
 
 	// Start transaction
@@ -36,10 +49,14 @@ func (s *PostService) CreatePost(userID int, title, content string, categories [
 		return fmt.Errorf("failed to get post ID: %w", err)
 	}
 
-	// Insert categories
-	categoryStmt := `INSERT INTO categories (post_id, category) VALUES (?, ?)`
-	for _, category := range categories {
-		if _, err := tx.Exec(categoryStmt, postID, category); err != nil {
+	// Link categories; every slug must already be in the catalogue
+	categoryIDs, err := resolveCategories(tx, categories)
+	if err != nil {
+		return err
+	}
+	categoryStmt := `INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)`
+	for _, categoryID := range categoryIDs {
+		if _, err := tx.Exec(categoryStmt, postID, categoryID); err != nil {
 			return fmt.Errorf("category insertion failed: %w", err)
 		}
 	}
@@ -52,45 +69,257 @@ func (s *PostService) CreatePost(userID int, title, content string, categories [
 	return nil
 }
 
-func (s *PostService) GetPosts(limit, offset int) ([]models.Post, error) {
-	query := `SELECT id, user_id, title, content, created_at 
-	          FROM posts ORDER BY created_at DESC LIMIT ? OFFSET ?`
+// postColumns selects a post with its author, reaction counts and the
+// viewer's own reaction; it expects posts aliased as p and users as u, and
+// takes the viewer's user ID as its only parameter.
+const postColumns = `p.id, p.user_id, u.nickname, p.title, p.content, p.created_at,
+	(SELECT COUNT(*) FROM reactions r
+	 WHERE r.content_type = 'post' AND r.content_id = p.id AND r.reaction_type = 'like'),
+	(SELECT COUNT(*) FROM reactions r
+	 WHERE r.content_type = 'post' AND r.content_id = p.id AND r.reaction_type = 'dislike'),
+	COALESCE((SELECT r.reaction_type FROM reactions r
+	 WHERE r.content_type = 'post' AND r.content_id = p.id AND r.user_id = ?), '')`
 
-	rows, err := s.DB.Query(query, limit, offset)
+func scanPost(row interface{ Scan(...interface{}) error }, post *models.Post) error {
+	return row.Scan(
+		&post.ID,
+		&post.UserID,
+		&post.Author,
+		&post.Title,
+		&post.Content,
+		&post.CreatedAt,
+		&post.Likes,
+		&post.Dislikes,
+		&post.UserReaction,
+	)
+}
+
+// GetPosts returns a page of posts, newest first, optionally restricted to
+// the category with the given slug. viewerID is the user whose own
+// reactions are reported, or 0 for an anonymous viewer.
+func (s *PostService) GetPosts(viewerID int, category string, limit, offset int) ([]models.Post, error) {
+	query := `SELECT ` + postColumns + `
+	          FROM posts p
+	          JOIN users u ON u.id = p.user_id
+	          WHERE ? = '' OR EXISTS (
+	              SELECT 1 FROM post_categories pc
+	              JOIN categories c ON c.id = pc.category_id
+	              WHERE pc.post_id = p.id AND c.slug = ?)
+	          ORDER BY p.created_at DESC LIMIT ? OFFSET ?`
+
+	rows, err := s.DB.Query(query, viewerID, category, category, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("database error: %w", err)
 	}
 	defer rows.Close()
 
-	var posts []models.Post
+	posts := []models.Post{}
 	for rows.Next() {
 		var post models.Post
-		if err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Title,
-			&post.Content,
-			&post.CreatedAt,
-		); err != nil {
+		if err := scanPost(rows, &post); err != nil {
 			return nil, fmt.Errorf("scan error: %w", err)
 		}
 		posts = append(posts, post)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	for i := range posts {
+		if posts[i].Categories, err = s.getPostCategories(posts[i].ID); err != nil {
+			return nil, err
+		}
+	}
 	return posts, nil
 }
 
+// GetPost returns a single post with its author, categories and reaction
+// counts, or ErrPostNotFound.
+func (s *PostService) GetPost(viewerID, postID int) (*models.Post, error) {
+	query := `SELECT ` + postColumns + `
+	          FROM posts p
+	          JOIN users u ON u.id = p.user_id
+	          WHERE p.id = ?`
+
+	var post models.Post
+	if err := scanPost(s.DB.QueryRow(query, viewerID, postID), &post); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPostNotFound
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	var err error
+	if post.Categories, err = s.getPostCategories(post.ID); err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func (s *PostService) getPostCategories(postID int) ([]models.Category, error) {
+	query := `SELECT c.id, c.slug, c.name
+	          FROM post_categories pc
+	          JOIN categories c ON c.id = pc.category_id
+	          WHERE pc.post_id = ?
+	          ORDER BY c.name`
+
+	rows, err := s.DB.Query(query, postID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var category models.Category
+		if err := rows.Scan(&category.ID, &category.Slug, &category.Name); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// GetComments returns a page of a post's comments, oldest first, with
+// their authors, reaction counts and the viewer's own reaction.
+func (s *PostService) GetComments(viewerID, postID, limit, offset int) ([]models.Comment, error) {
+	query := `SELECT c.id, c.post_id, c.user_id, u.nickname, c.comment, c.created_at,
+	                 (SELECT COUNT(*) FROM reactions r
+	                  WHERE r.content_type = 'comment' AND r.content_id = c.id AND r.reaction_type = 'like'),
+	                 (SELECT COUNT(*) FROM reactions r
+	                  WHERE r.content_type = 'comment' AND r.content_id = c.id AND r.reaction_type = 'dislike'),
+	                 COALESCE((SELECT r.reaction_type FROM reactions r
+	                  WHERE r.content_type = 'comment' AND r.content_id = c.id AND r.user_id = ?), '')
+	          FROM comments c
+	          JOIN users u ON u.id = c.user_id
+	          WHERE c.post_id = ?
+	          ORDER BY c.created_at ASC, c.id ASC
+	          LIMIT ? OFFSET ?`
+
+	rows, err := s.DB.Query(query, viewerID, postID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	comments := []models.Comment{}
+	for rows.Next() {
+		var comment models.Comment
+		if err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.UserID,
+			&comment.Author,
+			&comment.Content,
+			&comment.CreatedAt,
+			&comment.Likes,
+			&comment.Dislikes,
+			&comment.UserReaction,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+func (s *PostService) CountComments(postID int) (int, error) {
+	var count int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = ?", postID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return count, nil
+}
+
+// GetPostDetail returns a post together with one page of its comments.
+func (s *PostService) GetPostDetail(viewerID, postID, limit, offset int) (*models.PostDetail, error) {
+	post, err := s.GetPost(viewerID, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &models.PostDetail{Post: *post}
+	if detail.CommentCount, err = s.CountComments(postID); err != nil {
+		return nil, err
+	}
+	if detail.Comments, err = s.GetComments(viewerID, postID, limit, offset); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+func (s *PostService) postExists(postID int) (bool, error) {
+	var exists bool
+	if err := s.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	return exists, nil
+}
+
 // Similar methods for comments and reactions
 func (s *PostService) CreateComment(postID, userID int, content string) error {
-	if content == "" {
-		return errors.New("comment cannot be empty")
+	if strings.TrimSpace(content) == "" {
+		return FieldErrors(map[string]string{"content": "comment cannot be empty"})
+	}
+
+	exists, err := s.postExists(postID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrPostNotFound
 	}
 
 	stmt := `INSERT INTO comments (post_id, user_id, comment, created_at) 
 	         VALUES (?, ?, ?, ?)`
 
-	_, err := s.DB.Exec(stmt, postID, userID, content, time.Now().Format(time.RFC3339))
+	_, err = s.DB.Exec(stmt, postID, userID, content, time.Now().Format(time.RFC3339))
 	if err != nil {
 		return fmt.Errorf("failed to create comment: %w", err)
 	}
 	return nil
 }
+
+// DeletePost deletes a post with its comments and reactions. Authors may
+// delete their own posts; anyone else needs auth.PermDeleteAnyPost.
+func (s *PostService) DeletePost(user *models.User, postID int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("transaction start failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	var authorID int
+	err = tx.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		return ErrPostNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if authorID != user.ID {
+		if err := Authorize(user.Role, auth.PermDeleteAnyPost); err != nil {
+			return err
+		}
+	}
+
+	// Reactions point at posts and comments without a foreign key, so they
+	// have to be deleted here; the rest cascades, but isn't relied on
+	stmts := []string{
+		`DELETE FROM reactions WHERE content_type = 'comment'
+		 AND content_id IN (SELECT id FROM comments WHERE post_id = ?)`,
+		`DELETE FROM reactions WHERE content_type = 'post' AND content_id = ?`,
+		`DELETE FROM comments WHERE post_id = ?`,
+		`DELETE FROM post_categories WHERE post_id = ?`,
+		`DELETE FROM posts WHERE id = ?`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, postID); err != nil {
+			return fmt.Errorf("post deletion failed: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("transaction commit failed: %w", err)
+	}
+	return nil
+}
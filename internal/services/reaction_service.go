@@ -0,0 +1,108 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"real-time-forum/internal/models"
+	"time"
+)
+
+var (
+	ErrCommentNotFound = NotFoundError("comment not found")
+	ErrInvalidReaction = ValidationError(`reaction must be "like" or "dislike"`)
+)
+
+type ReactionService struct {
+	DB *sql.DB
+}
+
+// React applies a user's reaction to a post or comment. Repeating the
+// user's current reaction removes it, a different one replaces it, and an
+// empty reaction clears it. Uniqueness per user and item is enforced by
+// the idx_reactions_user_content index.
+func (s *ReactionService) React(userID int, contentType string, contentID int, reaction string) (*models.ReactionSummary, error) {
+	if reaction != "" && reaction != "like" && reaction != "dislike" {
+		return nil, ErrInvalidReaction
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("transaction start failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := contentExists(tx, contentType, contentID); err != nil {
+		return nil, err
+	}
+
+	var current string
+	err = tx.QueryRow(`SELECT reaction_type FROM reactions
+	                   WHERE user_id = ? AND content_type = ? AND content_id = ?`,
+		userID, contentType, contentID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if reaction == "" || reaction == current {
+		_, err = tx.Exec(`DELETE FROM reactions WHERE user_id = ? AND content_type = ? AND content_id = ?`,
+			userID, contentType, contentID)
+	} else {
+		_, err = tx.Exec(`INSERT INTO reactions (user_id, content_type, content_id, reaction_type, created_at)
+		                  VALUES (?, ?, ?, ?, ?)
+		                  ON CONFLICT (user_id, content_type, content_id)
+		                  DO UPDATE SET reaction_type = excluded.reaction_type, created_at = excluded.created_at`,
+			userID, contentType, contentID, reaction, time.Now().UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save reaction: %w", err)
+	}
+
+	summary, err := reactionSummary(tx, userID, contentType, contentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("transaction commit failed: %w", err)
+	}
+	return summary, nil
+}
+
+func contentExists(tx *sql.Tx, contentType string, contentID int) error {
+	var table string
+	var notFound error
+	switch contentType {
+	case "post":
+		table, notFound = "posts", ErrPostNotFound
+	case "comment":
+		table, notFound = "comments", ErrCommentNotFound
+	default:
+		return fmt.Errorf("unknown content type %q", contentType)
+	}
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id = ?)", contentID).Scan(&exists); err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if !exists {
+		return notFound
+	}
+	return nil
+}
+
+func reactionSummary(tx *sql.Tx, userID int, contentType string, contentID int) (*models.ReactionSummary, error) {
+	summary := &models.ReactionSummary{ContentType: contentType, ContentID: contentID}
+	query := `SELECT
+	            COALESCE(SUM(reaction_type = 'like'), 0),
+	            COALESCE(SUM(reaction_type = 'dislike'), 0),
+	            COALESCE(MAX(CASE WHEN user_id = ? THEN reaction_type END), '')
+	          FROM reactions
+	          WHERE content_type = ? AND content_id = ?`
+	if err := tx.QueryRow(query, userID, contentType, contentID).Scan(
+		&summary.Likes,
+		&summary.Dislikes,
+		&summary.UserReaction,
+	); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"real-time-forum/internal/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var ErrEmptySearch = ValidationError("search query needs at least one word")
+
+// SearchQuery is a parsed search string. Text holds the FTS5 MATCH
+// expression built from the free-text part of the query.
+type SearchQuery struct {
+	Text     string
+	Author   string
+	Category string
+	Types    map[string]bool
+}
+
+// ParseSearchQuery parses the search box syntax:
+//
+//	word           matches the word
+//	word*          matches words starting with "word"
+//	"a phrase"     matches the words in that order
+//	author:name    only content written or sent by that nickname
+//	category:slug  only posts (and their comments) in that category
+//	in:posts       only posts; also in:comments and in:messages
+//
+// Every word and phrase must match. User input is always quoted before it
+// reaches FTS5, so operators like NEAR or column filters can't be injected.
+func ParseSearchQuery(raw string) (*SearchQuery, error) {
+	q := &SearchQuery{Types: make(map[string]bool)}
+	var terms []string
+
+	for _, token := range tokenizeSearch(raw) {
+		if token.phrase {
+			if text := strings.TrimSpace(token.text); text != "" {
+				terms = append(terms, ftsQuote(text))
+			}
+			continue
+		}
+
+		if key, value, ok := strings.Cut(token.text, ":"); ok && value != "" {
+			switch strings.ToLower(key) {
+			case "author":
+				q.Author = value
+				continue
+			case "category":
+				q.Category = strings.ToLower(value)
+				continue
+			case "in":
+				switch t := strings.TrimSuffix(strings.ToLower(value), "s"); t {
+				case "post", "comment", "message":
+					q.Types[t] = true
+				default:
+					return nil, ValidationError("unknown search scope %q", value)
+				}
+				continue
+			}
+		}
+
+		prefix := strings.HasSuffix(token.text, "*")
+		word := strings.TrimFunc(token.text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if word == "" {
+			continue
+		}
+		term := ftsQuote(word)
+		if prefix {
+			term += " *"
+		}
+		terms = append(terms, term)
+	}
+
+	if len(terms) == 0 {
+		return nil, ErrEmptySearch
+	}
+	q.Text = strings.Join(terms, " ")
+	return q, nil
+}
+
+type searchToken struct {
+	text   string
+	phrase bool
+}
+
+// tokenizeSearch splits on whitespace, keeping double-quoted phrases whole.
+// An unterminated quote runs to the end of the input.
+func tokenizeSearch(raw string) []searchToken {
+	var tokens []searchToken
+	var current strings.Builder
+	inQuote := false
+
+	flush := func(phrase bool) {
+		if current.Len() > 0 || phrase {
+			tokens = append(tokens, searchToken{text: current.String(), phrase: phrase})
+		}
+		current.Reset()
+	}
+
+	for _, r := range raw {
+		switch {
+		case r == '"':
+			if inQuote {
+				flush(true)
+			} else {
+				flush(false)
+			}
+			inQuote = !inQuote
+		case unicode.IsSpace(r) && !inQuote:
+			flush(false)
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush(inQuote)
+	return tokens
+}
+
+func ftsQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// Snippet markers are control characters that can't appear in the HTML we
+// produce, so the snippet can be escaped before the markers become <mark>.
+const (
+	snippetOpen  = "\x02"
+	snippetClose = "\x03"
+)
+
+func highlight(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, snippetOpen, "<mark>")
+	return strings.ReplaceAll(escaped, snippetClose, "</mark>")
+}
+
+type SearchService struct {
+	DB *sql.DB
+}
+
+// Search runs q over posts, comments and the viewer's own private
+// messages, best matches first. Messages are only searched for a logged-in
+// viewer and only those they sent or received, mirroring
+// ChatService.GetMessages. Snippets are HTML-escaped with matches wrapped in
+// <mark>.
+func (s *SearchService) Search(viewerID int, q *SearchQuery, limit, offset int) ([]models.SearchResult, error) {
+	wants := func(t string) bool { return len(q.Types) == 0 || q.Types[t] }
+	// Each source returns its own best limit+offset hits; the merged list
+	// is then cut to the requested page.
+	n := limit + offset
+
+	results := []models.SearchResult{}
+	if wants("post") {
+		posts, err := s.searchPosts(q, n)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, posts...)
+	}
+	if wants("comment") {
+		comments, err := s.searchComments(q, n)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, comments...)
+	}
+	if wants("message") && viewerID != 0 && q.Category == "" {
+		messages, err := s.searchMessages(viewerID, q, n)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, messages...)
+	}
+
+	// bm25 scores are lower for better matches
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank < results[j].Rank })
+
+	if offset >= len(results) {
+		return []models.SearchResult{}, nil
+	}
+	results = results[offset:]
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *SearchService) searchPosts(q *SearchQuery, limit int) ([]models.SearchResult, error) {
+	query := `SELECT p.id, p.id, p.title, u.id, u.nickname, p.created_at,
+	                 snippet(posts_fts, -1, ?, ?, '…', 16),
+	                 bm25(posts_fts, 5.0, 1.0)
+	          FROM posts_fts
+	          JOIN posts p ON p.id = posts_fts.rowid
+	          JOIN users u ON u.id = p.user_id
+	          WHERE posts_fts MATCH ?
+	            AND (? = '' OR u.nickname = ? COLLATE NOCASE)
+	            AND (? = '' OR EXISTS (
+	                SELECT 1 FROM post_categories pc
+	                JOIN categories c ON c.id = pc.category_id
+	                WHERE pc.post_id = p.id AND c.slug = ?))
+	          ORDER BY bm25(posts_fts, 5.0, 1.0)
+	          LIMIT ?`
+
+	return s.runSearch("post", query, snippetOpen, snippetClose, q.Text,
+		q.Author, q.Author, q.Category, q.Category, limit)
+}
+
+func (s *SearchService) searchComments(q *SearchQuery, limit int) ([]models.SearchResult, error) {
+	query := `SELECT c.id, p.id, p.title, u.id, u.nickname, c.created_at,
+	                 snippet(comments_fts, 0, ?, ?, '…', 16),
+	                 bm25(comments_fts)
+	          FROM comments_fts
+	          JOIN comments c ON c.id = comments_fts.rowid
+	          JOIN posts p ON p.id = c.post_id
+	          JOIN users u ON u.id = c.user_id
+	          WHERE comments_fts MATCH ?
+	            AND (? = '' OR u.nickname = ? COLLATE NOCASE)
+	            AND (? = '' OR EXISTS (
+	                SELECT 1 FROM post_categories pc
+	                JOIN categories cat ON cat.id = pc.category_id
+	                WHERE pc.post_id = p.id AND cat.slug = ?))
+	          ORDER BY bm25(comments_fts)
+	          LIMIT ?`
+
+	return s.runSearch("comment", query, snippetOpen, snippetClose, q.Text,
+		q.Author, q.Author, q.Category, q.Category, limit)
+}
+
+func (s *SearchService) searchMessages(viewerID int, q *SearchQuery, limit int) ([]models.SearchResult, error) {
+	query := `SELECT m.id, 0, '', u.id, u.nickname, m.created_at,
+	                 snippet(messages_fts, 0, ?, ?, '…', 16),
+	                 bm25(messages_fts)
+	          FROM messages_fts
+	          JOIN messages m ON m.id = messages_fts.rowid
+	          JOIN users u ON u.id = m.sender_id
+	          WHERE messages_fts MATCH ?
+	            AND (m.sender_id = ? OR m.receiver_id = ?)
+	            AND (? = '' OR u.nickname = ? COLLATE NOCASE)
+	          ORDER BY bm25(messages_fts)
+	          LIMIT ?`
+
+	return s.runSearch("message", query, snippetOpen, snippetClose, q.Text,
+		viewerID, viewerID, q.Author, q.Author, limit)
+}
+
+func (s *SearchService) runSearch(resultType, query string, args ...interface{}) ([]models.SearchResult, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		result := models.SearchResult{Type: resultType}
+		var snippet string
+		if err := rows.Scan(
+			&result.ID,
+			&result.PostID,
+			&result.Title,
+			&result.AuthorID,
+			&result.Author,
+			&result.CreatedAt,
+			&snippet,
+			&result.Rank,
+		); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		result.Snippet = highlight(snippet)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"real-time-forum/internal/auth"
+)
+
+// Purposes of user tokens. A token only works for the purpose it was
+// issued for.
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	// PurposeLogin2FA marks a login whose password was right but which
+	// still needs a two-factor code.
+	PurposeLogin2FA = "login_2fa"
+)
+
+// ErrInvalidToken is returned for a token that doesn't exist, has expired
+// or has been used.
+var ErrInvalidToken = ValidationError("this link is invalid or has expired")
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// issueToken creates a token for userID that is valid for ttl and returns
+// it. Earlier unused tokens for the same purpose stop working, so only the
+// latest link a user was sent is live.
+func issueToken(db execer, userID int, purpose string, ttl time.Duration) (string, error) {
+	token, err := auth.GenerateSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	if _, err := db.Exec("DELETE FROM user_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+		userID, purpose); err != nil {
+		return "", fmt.Errorf("failed to revoke old tokens: %w", err)
+	}
+	_, err = db.Exec(`INSERT INTO user_tokens (user_id, purpose, token_hash, created_at, expires_at)
+	                  VALUES (?, ?, ?, ?, ?)`,
+		userID,
+		purpose,
+		auth.HashToken(token),
+		now.Format(time.RFC3339),
+		now.Add(ttl).Format(time.RFC3339),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+	return token, nil
+}
+
+// lookupToken returns the user a live token was issued to without using it
+// up, or ErrInvalidToken.
+func lookupToken(db execer, purpose, token string) (int, error) {
+	var userID int
+	err := db.QueryRow(`SELECT user_id FROM user_tokens
+	                    WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`,
+		auth.HashToken(token), purpose, time.Now().UTC().Format(time.RFC3339),
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidToken
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up token: %w", err)
+	}
+	return userID, nil
+}
+
+// consumeToken marks a live token used and returns the user it was issued
+// to, or ErrInvalidToken.
+func consumeToken(db execer, purpose, token string) (int, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var userID int
+	err := db.QueryRow(`UPDATE user_tokens SET used_at = ?
+	                    WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+	                    RETURNING user_id`,
+		now, auth.HashToken(token), purpose, now,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidToken
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to use token: %w", err)
+	}
+	return userID, nil
+}
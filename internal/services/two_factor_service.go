@@ -0,0 +1,212 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"real-time-forum/internal/auth"
+)
+
+const (
+	// TwoFactorLoginTTL is how long a user has to enter their code after
+	// their password.
+	TwoFactorLoginTTL = 5 * time.Minute
+	// RecoveryCodeCount is how many recovery codes a user is given.
+	RecoveryCodeCount = 10
+	// totpIssuer names the forum in authenticator apps.
+	totpIssuer = "Real-Time Forum"
+)
+
+var (
+	// ErrInvalidCode is returned at login for a wrong, reused or expired
+	// TOTP or recovery code.
+	ErrInvalidCode = UnauthorizedError("invalid authentication code")
+	// ErrLoginExpired is returned for a two-factor login token that is
+	// unknown, used or past TwoFactorLoginTTL.
+	ErrLoginExpired = UnauthorizedError("this login has expired; log in again")
+)
+
+// TwoFactorService enrolls users in TOTP two-factor authentication and
+// checks their codes.
+type TwoFactorService struct {
+	DB *sql.DB
+}
+
+// TwoFactorSetup is what an authenticator app needs to start generating
+// codes: the provisioning URI, usually shown as a QR code, and the same
+// secret for typing in by hand.
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// Setup generates a new TOTP secret for the user. Two-factor login isn't
+// on until Enable confirms the user's app produces matching codes;
+// calling Setup again before then starts over with a new secret.
+func (s *TwoFactorService) Setup(userID int) (*TwoFactorSetup, error) {
+	var email string
+	var enabledAt *time.Time
+	err := s.DB.QueryRow("SELECT email, totp_enabled_at FROM users WHERE id = ?", userID).Scan(&email, &enabledAt)
+	if err == sql.ErrNoRows {
+		return nil, NotFoundError("user not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if enabledAt != nil {
+		return nil, ConflictError("two-factor authentication is already on")
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.DB.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ? AND totp_enabled_at IS NULL",
+		secret, userID); err != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	return &TwoFactorSetup{Secret: secret, URI: auth.TOTPURI(totpIssuer, email, secret)}, nil
+}
+
+// Enable turns two-factor login on once code shows the user's
+// authenticator has the secret from Setup. It returns the user's recovery
+// codes, which are shown this once and only stored hashed.
+func (s *TwoFactorService) Enable(userID int, code string) ([]string, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabledAt *time.Time
+	var lastStep int64
+	err = tx.QueryRow("SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = ?", userID).
+		Scan(&secret, &enabledAt, &lastStep)
+	if err == sql.ErrNoRows {
+		return nil, NotFoundError("user not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if enabledAt != nil {
+		return nil, ConflictError("two-factor authentication is already on")
+	}
+	if !secret.Valid {
+		return nil, ValidationError("start two-factor setup first")
+	}
+
+	step, ok := auth.MatchTOTP(secret.String, code, time.Now(), lastStep)
+	if !ok {
+		return nil, FieldErrors(map[string]string{"code": "code is incorrect"})
+	}
+	if _, err := tx.Exec("UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?",
+		time.Now().UTC().Format(time.RFC3339), step, userID); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit two-factor setup: %w", err)
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor login off. The user has to confirm their
+// password, so a session left open somewhere can't be used to do it.
+func (s *TwoFactorService) Disable(userID int, password string) error {
+	var hashed string
+	var enabledAt *time.Time
+	err := s.DB.QueryRow("SELECT password, totp_enabled_at FROM users WHERE id = ?", userID).Scan(&hashed, &enabledAt)
+	if err == sql.ErrNoRows {
+		return NotFoundError("user not found")
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if !auth.ComparePasswords(hashed, password) {
+		return FieldErrors(map[string]string{"password": "password is incorrect"})
+	}
+	if enabledAt == nil {
+		return ConflictError("two-factor authentication is not on")
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?",
+		userID); err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	return tx.Commit()
+}
+
+// checkCode accepts a TOTP code or an unused recovery code for the user,
+// using it up, or returns ErrInvalidCode.
+func checkCode(tx *sql.Tx, userID int, code string) error {
+	var secret string
+	var lastStep int64
+	err := tx.QueryRow("SELECT totp_secret, totp_last_step FROM users WHERE id = ? AND totp_enabled_at IS NOT NULL",
+		userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		// Two-factor login was turned off since the password was checked
+		return ErrLoginExpired
+	}
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	if step, ok := auth.MatchTOTP(secret, code, time.Now(), lastStep); ok {
+		// Only move forward, so two logins racing with one code can't both
+		// succeed
+		res, err := tx.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?",
+			step, userID, step)
+		if err != nil {
+			return fmt.Errorf("failed to record TOTP code: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return nil
+		}
+		return ErrInvalidCode
+	}
+
+	res, err := tx.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC().Format(time.RFC3339), userID, auth.HashToken(auth.NormalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil
+	}
+	return ErrInvalidCode
+}
+
+// replaceRecoveryCodes gives the user a fresh set of recovery codes,
+// invalidating any they had, and returns them.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := auth.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, auth.HashToken(auth.NormalizeRecoveryCode(code))); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
@@ -2,45 +2,45 @@ package services
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
 	"real-time-forum/internal/auth"
 	"real-time-forum/internal/models"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials = UnauthorizedError("invalid credentials")
+	ErrBanned             = ForbiddenError("this account has been banned")
 )
 
 type UserService struct {
 	DB *sql.DB
+	// SessionDuration is how long sessions started by Login and
+	// LoginTwoFactor last without activity.
+	SessionDuration time.Duration
 }
 
+// Register validates and stores a new account, setting user.ID. Field
+// errors and duplicate emails or nicknames are reported as *Error with
+// Fields set.
 func (s *UserService) Register(user *models.User) error {
-	// Validate input
-	if user.Nickname == "" || user.Email == "" || user.Password == "" {
-		return errors.New("all fields are required")
+	if err := ValidateUser(user); err != nil {
+		return err
 	}
 
-	// Check if email or nickname exists
-	var count int
-	err := s.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = ? OR nickname = ?", 
-		user.Email, user.Nickname).Scan(&count)
-	if err != nil {
-		return fmt.Errorf("database error: %w", err)
-	}
-	if count > 0 {
-		return errors.New("email or nickname already exists")
-	}
-
-	// Hash password
 	hashedPassword, err := auth.HashPassword(user.Password)
 	if err != nil {
 		return fmt.Errorf("password hashing failed: %w", err)
 	}
 	user.Password = hashedPassword
 
-	// Insert user
-	stmt := `INSERT INTO users (first_name, last_name, email, gender, age, nickname, password) 
+	// The unique indexes decide whether the email or nickname is taken, so
+	// two registrations racing for the same name can't both succeed
+	stmt := `INSERT INTO users (first_name, last_name, email, gender, age, nickname, password)
 	         VALUES (?, ?, ?, ?, ?, ?, ?)`
-	
-	_, err = s.DB.Exec(stmt,
+
+	res, err := s.DB.Exec(stmt,
 		user.FirstName,
 		user.LastName,
 		user.Email,
@@ -49,52 +49,157 @@ func (s *UserService) Register(user *models.User) error {
 		user.Nickname,
 		user.Password,
 	)
-	
+	if columns, ok := uniqueViolation(err); ok {
+		field := "nickname"
+		if strings.Contains(columns, "email") {
+			field = "email"
+		}
+		return &Error{
+			Kind:    KindConflict,
+			Message: field + " is already taken",
+			Fields:  map[string]string{field: field + " is already taken"},
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("user creation failed: %w", err)
 	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get user ID: %w", err)
+	}
+	user.ID = int(id)
 	return nil
 }
 
-func (s *UserService) Login(emailOrNickname, password string) (string, error) {
+// LoginResult is what a correct password gets. Users without two-factor
+// login get a session Token straight away; the rest get a TwoFactorToken
+// to exchange for one, along with a code, through LoginTwoFactor.
+type LoginResult struct {
+	Token          string
+	TwoFactorToken string
+}
+
+func (s *UserService) Login(emailOrNickname, password, userAgent, ip string) (*LoginResult, error) {
 	var user models.User
-	query := `SELECT id, password FROM users WHERE email = ? OR nickname = ?`
-	
+	var banned bool
+	query := `SELECT id, password, totp_enabled_at IS NOT NULL, banned_at IS NOT NULL
+	          FROM users WHERE email = ? COLLATE NOCASE OR nickname = ? COLLATE NOCASE`
+
 	err := s.DB.QueryRow(query, emailOrNickname, emailOrNickname).Scan(
 		&user.ID,
 		&user.Password,
+		&user.TwoFactorEnabled,
+		&banned,
 	)
-	
+
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", errors.New("invalid credentials")
+			return nil, ErrInvalidCredentials
 		}
-		return "", fmt.Errorf("database error: %w", err)
+		return nil, fmt.Errorf("database error: %w", err)
 	}
 
 	// Verify password
 	if !auth.ComparePasswords(user.Password, password) {
-		return "", errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
+	}
+	// Only say so once the password is right, so the ban doesn't tell
+	// anyone guessing that the account exists
+	if banned {
+		return nil, ErrBanned
+	}
+
+	if user.TwoFactorEnabled {
+		pending, err := issueToken(s.DB, user.ID, PurposeLogin2FA, TwoFactorLoginTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{TwoFactorToken: pending}, nil
 	}
 
-	// Generate session token
-	token, err := auth.GenerateSessionToken()
+	// Start a new session alongside any the user already has
+	token, err := auth.CreateSession(s.DB, user.ID, userAgent, ip, s.SessionDuration)
 	if err != nil {
-		return "", fmt.Errorf("session creation failed: %w", err)
+		return nil, fmt.Errorf("session creation failed: %w", err)
+	}
+
+	return &LoginResult{Token: token}, nil
+}
+
+// PendingLogin returns the user a two-factor login token belongs to, or
+// ErrLoginExpired.
+func (s *UserService) PendingLogin(pending string) (int, error) {
+	userID, err := lookupToken(s.DB, PurposeLogin2FA, pending)
+	if err == ErrInvalidToken {
+		return 0, ErrLoginExpired
+	}
+	return userID, err
+}
+
+// LoginTwoFactor finishes a login Login started, checking code, a TOTP or
+// recovery code, and returns the new session's token. A wrong code
+// returns ErrInvalidCode and leaves the pending login open to try again.
+func (s *UserService) LoginTwoFactor(pending, code, userAgent, ip string) (string, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	// Update session token
-	_, err = s.DB.Exec("UPDATE users SET session_token = ? WHERE id = ?", token, user.ID)
+	userID, err := lookupToken(tx, PurposeLogin2FA, pending)
+	if err == ErrInvalidToken {
+		return "", ErrLoginExpired
+	}
 	if err != nil {
-		return "", fmt.Errorf("session update failed: %w", err)
+		return "", err
+	}
+	if err := checkCode(tx, userID, code); err != nil {
+		return "", err
+	}
+	if _, err := consumeToken(tx, PurposeLogin2FA, pending); err == ErrInvalidToken {
+		return "", ErrLoginExpired
+	} else if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit login: %w", err)
 	}
 
+	token, err := auth.CreateSession(s.DB, userID, userAgent, ip, s.SessionDuration)
+	if err != nil {
+		return "", fmt.Errorf("session creation failed: %w", err)
+	}
 	return token, nil
 }
 
-func (s *UserService) Logout(token string) error {
-	_, err := s.DB.Exec("UPDATE users SET session_token = NULL WHERE session_token = ?", token)
+// LoginNames returns the names the user can log in with.
+func (s *UserService) LoginNames(userID int) (email, nickname string, err error) {
+	err = s.DB.QueryRow("SELECT email, nickname FROM users WHERE id = ?", userID).Scan(&email, &nickname)
+	if err == sql.ErrNoRows {
+		return "", "", NotFoundError("user not found")
+	}
 	if err != nil {
+		return "", "", fmt.Errorf("database error: %w", err)
+	}
+	return email, nickname, nil
+}
+
+// FindUserID returns the ID of the user with the given email or nickname.
+func (s *UserService) FindUserID(emailOrNickname string) (int, error) {
+	var userID int
+	err := s.DB.QueryRow("SELECT id FROM users WHERE email = ? COLLATE NOCASE OR nickname = ? COLLATE NOCASE",
+		emailOrNickname, emailOrNickname).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, NotFoundError("user not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+	return userID, nil
+}
+
+func (s *UserService) Logout(token string) error {
+	if err := auth.DeleteSession(s.DB, token); err != nil {
 		return fmt.Errorf("logout failed: %w", err)
 	}
 	return nil
@@ -116,4 +221,39 @@ func (s *UserService) GetAllUsers() ([]string, error) {
 		users = append(users, nickname)
 	}
 	return users, nil
-}
\ No newline at end of file
+}
+
+// ListUsers returns every registered user with their last-seen time,
+// sorted by nickname. Online is left for the caller to fill in from the
+// websocket hub.
+func (s *UserService) ListUsers() ([]models.UserPresence, error) {
+	rows, err := s.DB.Query("SELECT id, nickname, last_seen_at FROM users ORDER BY nickname COLLATE NOCASE")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.UserPresence{}
+	for rows.Next() {
+		var user models.UserPresence
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&user.ID, &user.Nickname, &lastSeen); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if lastSeen.Valid {
+			user.LastSeenAt = &lastSeen.Time
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// TouchLastSeen records that the user was seen at the given time.
+func (s *UserService) TouchLastSeen(userID int, at time.Time) error {
+	_, err := s.DB.Exec("UPDATE users SET last_seen_at = ? WHERE id = ?",
+		at.UTC().Format(time.RFC3339), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update last seen: %w", err)
+	}
+	return nil
+}
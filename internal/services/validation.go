@@ -0,0 +1,110 @@
+package services
+
+import (
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"real-time-forum/internal/models"
+)
+
+// Registration limits
+const (
+	MinNicknameLength = 3
+	MaxNicknameLength = 20
+	MaxNameLength     = 50
+	MaxEmailLength    = 254
+	MinAge            = 13
+	MaxAge            = 120
+	MinPasswordLength = 8
+	// MaxPasswordLength is bcrypt's limit; longer passwords would be
+	// silently truncated.
+	MaxPasswordLength = 72
+)
+
+// Genders lists the accepted values of models.User.Gender.
+var Genders = []string{"male", "female", "other"}
+
+// Nicknames double as login names, so they can't contain @ and be mistaken
+// for an email address.
+var nicknamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidateUser checks a registration and returns every problem at once as
+// field errors, or nil. It trims and normalises user's fields in place.
+func ValidateUser(user *models.User) error {
+	user.FirstName = strings.TrimSpace(user.FirstName)
+	user.LastName = strings.TrimSpace(user.LastName)
+	user.Email = strings.TrimSpace(user.Email)
+	user.Nickname = strings.TrimSpace(user.Nickname)
+	user.Gender = strings.ToLower(strings.TrimSpace(user.Gender))
+
+	fields := make(map[string]string)
+	checkName(fields, "firstName", "first name", user.FirstName)
+	checkName(fields, "lastName", "last name", user.LastName)
+
+	if user.Email == "" {
+		fields["email"] = "email is required"
+	} else if len(user.Email) > MaxEmailLength {
+		fields["email"] = "email is too long"
+	} else if addr, err := mail.ParseAddress(user.Email); err != nil || addr.Address != user.Email {
+		fields["email"] = "email is not a valid address"
+	}
+
+	switch n := utf8.RuneCountInString(user.Nickname); {
+	case n == 0:
+		fields["nickname"] = "nickname is required"
+	case n < MinNicknameLength || n > MaxNicknameLength:
+		fields["nickname"] = "nickname must be 3 to 20 characters"
+	case !nicknamePattern.MatchString(user.Nickname):
+		fields["nickname"] = "nickname may only contain letters, digits, '.', '_' and '-'"
+	}
+
+	if user.Age < MinAge || user.Age > MaxAge {
+		fields["age"] = "age must be between 13 and 120"
+	}
+
+	if user.Gender == "" {
+		fields["gender"] = "gender is required"
+	} else if !slices.Contains(Genders, user.Gender) {
+		fields["gender"] = "gender must be one of " + strings.Join(Genders, ", ")
+	}
+
+	if msg := passwordProblem(user); msg != "" {
+		fields["password"] = msg
+	}
+
+	if len(fields) > 0 {
+		return FieldErrors(fields)
+	}
+	return nil
+}
+
+func checkName(fields map[string]string, field, label, value string) {
+	if value == "" {
+		fields[field] = label + " is required"
+	} else if utf8.RuneCountInString(value) > MaxNameLength {
+		fields[field] = label + " is too long"
+	}
+}
+
+// passwordProblem applies the password policy: 8 to 72 bytes with at
+// least one letter and one digit, and not just the nickname or email.
+func passwordProblem(user *models.User) string {
+	password := user.Password
+	switch {
+	case password == "":
+		return "password is required"
+	case len(password) < MinPasswordLength:
+		return "password must be at least 8 characters"
+	case len(password) > MaxPasswordLength:
+		return "password must be at most 72 bytes"
+	case !strings.ContainsFunc(password, unicode.IsLetter) || !strings.ContainsFunc(password, unicode.IsDigit):
+		return "password must contain a letter and a digit"
+	case strings.EqualFold(password, user.Nickname) || strings.EqualFold(password, user.Email):
+		return "password must not be your nickname or email"
+	}
+	return ""
+}
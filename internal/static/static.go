@@ -0,0 +1,198 @@
+// Package static serves the front end with content-hash ETags and
+// compressed variants.
+package static
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const indexFile = "index.html"
+
+// asset is one file with every encoding it can be served in.
+type asset struct {
+	contentType string
+	hash        string
+	// variants maps a content coding ("" for identity) to the body.
+	variants map[string][]byte
+}
+
+// Handler serves the files of an fs.FS. Paths without a file extension that
+// match no file get index.html, so client-side routes survive a reload.
+type Handler struct {
+	fsys fs.FS
+	// dev re-reads every file on each request, for editing the front end
+	// without restarting.
+	dev    bool
+	assets map[string]*asset
+}
+
+// New returns a handler for fsys. Unless dev is set, every file is read,
+// hashed and gzipped up front.
+func New(fsys fs.FS, dev bool) (*Handler, error) {
+	h := &Handler{fsys: fsys, dev: dev, assets: make(map[string]*asset)}
+	if dev {
+		return h, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !servable(name) {
+			return err
+		}
+		a, err := loadAsset(fsys, name)
+		if err != nil {
+			return err
+		}
+		h.assets[name] = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := h.assets[indexFile]; !ok {
+		return nil, errors.New("static files have no " + indexFile)
+	}
+	return h, nil
+}
+
+// servable leaves out compressed files left over from building the front
+// end and Go sources that sit next to the files on disk.
+func servable(name string) bool {
+	switch path.Ext(name) {
+	case ".gz", ".br", ".go":
+		return false
+	}
+	return true
+}
+
+func (h *Handler) lookup(name string) (*asset, error) {
+	if !h.dev {
+		a, ok := h.assets[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return a, nil
+	}
+	if !servable(name) {
+		return nil, fs.ErrNotExist
+	}
+	return loadAsset(h.fsys, name)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = indexFile
+	}
+	a, err := h.lookup(name)
+	if errors.Is(err, fs.ErrNotExist) && path.Ext(name) == "" {
+		name = indexFile
+		a, err = h.lookup(name)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	encoding := negotiate(r.Header.Get("Accept-Encoding"), a.variants)
+	header := w.Header()
+	header.Set("Content-Type", a.contentType)
+	header.Add("Vary", "Accept-Encoding")
+	// Asset names aren't content-hashed, so browsers must revalidate even
+	// scripts and styles; the ETag turns that into a 304.
+	if name == indexFile {
+		header.Set("Cache-Control", "no-cache")
+	} else {
+		header.Set("Cache-Control", "public, no-cache")
+	}
+	etag := a.hash
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+		etag += "-" + encoding
+	}
+	header.Set("ETag", strconv.Quote(etag))
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(a.variants[encoding]))
+}
+
+// compressible reports whether gzipping a file of this type is worthwhile.
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "svg")
+}
+
+func loadAsset(fsys fs.FS, name string) (*asset, error) {
+	body, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	a := &asset{
+		contentType: mime.TypeByExtension(path.Ext(name)),
+		hash:        hex.EncodeToString(sum[:16]),
+		variants:    map[string][]byte{"": body},
+	}
+	if a.contentType == "" {
+		a.contentType = http.DetectContentType(body)
+	}
+
+	if compressible(a.contentType) {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(body) {
+			a.variants["gzip"] = buf.Bytes()
+		}
+	}
+	return a, nil
+}
+
+// negotiate picks gzip if the asset has a gzip variant and the client
+// accepts it, and identity otherwise.
+func negotiate(acceptEncoding string, variants map[string][]byte) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(coding)] = true
+	}
+
+	if _, ok := variants["gzip"]; ok && (accepted["gzip"] || accepted["*"]) {
+		return "gzip"
+	}
+	return ""
+}
@@ -4,106 +4,224 @@ package websocket
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
-	"real-time-forum/internal/auth"
+	"net/url"
+	"real-time-forum/internal/config"
 	"real-time-forum/internal/models"
+	"real-time-forum/internal/ratelimit"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // In production, validate origin
-	},
+// Queued messages are written as one frame, separated by newlines. JSON
+// encoding escapes newlines, so they never occur inside a message.
+var newline = []byte{'\n'}
+
+// Configure applies the websocket settings. Call it before serving
+// connections.
+func (h *Hub) Configure(cfg config.WebSocketConfig) {
+	h.upgrader = newUpgrader(cfg.AllowedOrigins, cfg.ReadBufferSize, cfg.WriteBufferSize)
+	h.pump = cfg
+}
+
+// LimitFrames limits how many frames each user may send, over all their
+// connections. Frames over the limit are answered with an error and
+// dropped. Call it before serving connections.
+func (h *Hub) LimitFrames(l *ratelimit.Limiter) {
+	h.frames = l
+}
+
+// newUpgrader accepts handshakes from the forum's own origin and from
+// allowedOrigins. Any other page a logged-in user visits could otherwise
+// open a socket with their cookie and chat as them.
+func newUpgrader(allowedOrigins []string, readBufferSize, writeBufferSize int) websocket.Upgrader {
+	allowed := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		allowed[strings.TrimSuffix(strings.ToLower(origin), "/")] = true
+	}
+	return websocket.Upgrader{
+		ReadBufferSize:  readBufferSize,
+		WriteBufferSize: writeBufferSize,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				// Browsers always send Origin; other clients don't carry
+				// anyone else's cookies
+				return true
+			}
+			if allowed[strings.ToLower(origin)] {
+				return true
+			}
+			u, err := url.Parse(origin)
+			if err != nil || !strings.EqualFold(u.Host, r.Host) {
+				log.Printf("Rejected websocket from origin %q", origin)
+				return false
+			}
+			return true
+		},
+	}
 }
 
 type Client struct {
 	hub      *Hub
+	db       *sql.DB
 	conn     *websocket.Conn
 	send     chan []byte
 	nickname string
 	userID   int
+	// role is the user's role when they connected. Changing a user's role
+	// or banning them closes their connections, so it is never stale.
+	role      string
+	sessionID int
+	// closeCode and closeReason, set by the hub before it closes send,
+	// are reported to the peer in the close frame.
+	closeCode   int
+	closeReason string
 }
 
-func (c *Client) readPump() {
+func (c *Client) readPump(cfg config.WebSocketConfig) {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.unregisterClient(c)
 		c.conn.Close()
 	}()
 
+	c.conn.SetReadLimit(cfg.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+	})
+
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			switch {
+			case errors.Is(err, websocket.ErrReadLimit):
+				log.Printf("Closing connection from %s: frame larger than %d bytes", c.nickname, cfg.MaxMessageSize)
+			case errors.As(err, &netErr) && netErr.Timeout():
+				log.Printf("Closing connection from %s: no pong within %s", c.nickname, cfg.PongWait)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
+		c.conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
 
-		// Handle different message types
-		var wsMsg models.WebSocketMessage
+		var wsMsg inboundMessage
 		if err := json.Unmarshal(message, &wsMsg); err != nil {
 			log.Printf("Error unmarshaling message: %v", err)
 			continue
 		}
 
-		switch wsMsg.Type {
-		case "get_online_users":
-			// Client requested online users
-			c.hub.BroadcastOnlineUsers()
-		default:
-			// Broadcast other messages
-			c.hub.Broadcast <- message
+		handler, ok := frameHandlers[wsMsg.Type]
+		if !ok {
+			log.Printf("Ignoring unknown message type %q from %s", wsMsg.Type, c.nickname)
+			continue
+		}
+		if err := c.hub.frames.Allow(strconv.Itoa(c.userID)); err != nil {
+			c.sendError(wsMsg.Type, err)
+			continue
+		}
+		if err := handler(c, wsMsg.Payload); err != nil {
+			c.sendError(wsMsg.Type, err)
 		}
 	}
 }
 
-func (c *Client) writePump() {
+func (c *Client) writePump(cfg config.WebSocketConfig) {
+	ticker := time.NewTicker(cfg.PingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
+		c.hub.writers.Done()
 	}()
 
-	for message := range c.send {
-		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Printf("WebSocket write error: %v", err)
-			break
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+			if !ok {
+				// The hub closed the channel
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
+				return
+			}
+			if err := c.writeBatch(message); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
-func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	// Validate session
-	cookie, err := r.Cookie("session_token")
+// writeBatch writes message and whatever else is already queued as a
+// single frame.
+func (c *Client) writeBatch(message []byte) error {
+	w, err := c.conn.NextWriter(websocket.TextMessage)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		return err
 	}
+	w.Write(message)
 
-	user, err := auth.ValidateSession(db, cookie.Value)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
+	for i, n := 0, len(c.send); i < n; i++ {
+		queued, ok := <-c.send
+		if !ok {
+			break
+		}
+		w.Write(newline)
+		w.Write(queued)
+	}
+	return w.Close()
+}
+
+func (c *Client) closeMessage() []byte {
+	if c.closeCode == 0 {
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
 	}
+	return websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+}
 
+// ServeWs upgrades an authenticated request to a websocket for user's
+// session.
+func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request, db *sql.DB, session *models.Session, user *models.User) {
 	// Upgrade to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := hub.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
 
+	cfg := hub.pump
 	client := &Client{
-		hub:      hub,
-		conn:     conn,
-		send:     make(chan []byte, 256),
-		nickname: user.Nickname,
-		userID:   user.ID,
+		hub:       hub,
+		db:        db,
+		conn:      conn,
+		send:      make(chan []byte, cfg.SendBuffer),
+		nickname:  user.Nickname,
+		userID:    user.ID,
+		role:      user.Role,
+		sessionID: session.ID,
 	}
 
-	client.hub.register <- client
+	if !hub.registerClient(client) {
+		closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(cfg.WriteWait))
+		conn.Close()
+		return
+	}
 
 	// Start communication routines
-	go client.writePump()
-	go client.readPump()
+	go client.writePump(cfg)
+	go client.readPump(cfg)
 }
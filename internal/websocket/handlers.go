@@ -0,0 +1,267 @@
+package websocket
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"real-time-forum/internal/auth"
+	"real-time-forum/internal/models"
+	"real-time-forum/internal/ratelimit"
+	"real-time-forum/internal/services"
+)
+
+// inboundMessage is a frame received from a client. The payload is decoded
+// by the handler registered for its type.
+type inboundMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// frameHandler handles one type of inbound frame. A returned error is
+// reported back to the sending connection only.
+type frameHandler func(c *Client, payload json.RawMessage) error
+
+var frameHandlers = map[string]frameHandler{
+	"get_users":    handleGetUsers,
+	"chat_message": handleChatMessage,
+	"typing_start": handleTypingStart,
+	"typing_stop":  handleTypingStop,
+	"message_read": handleMessageRead,
+}
+
+// handleGetUsers replies with every registered user and their online
+// status; later changes arrive as presence frames.
+func handleGetUsers(c *Client, _ json.RawMessage) error {
+	users, err := c.hub.UserList(c.db)
+	if err != nil {
+		return err
+	}
+	c.hub.sendToClient(c, encodeMessage("users", users))
+	return nil
+}
+
+// UserList returns every registered user with Online set from the hub.
+func (h *Hub) UserList(db *sql.DB) ([]models.UserPresence, error) {
+	userService := services.UserService{DB: db}
+	users, err := userService.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Online = h.IsOnline(users[i].ID)
+	}
+	return users, nil
+}
+
+type chatMessageFrame struct {
+	ReceiverID int    `json:"receiverId"`
+	Content    string `json:"content"`
+	// ClientID is an opaque value chosen by the sender and echoed in the
+	// ack so it can match the ack to the message it sent.
+	ClientID string `json:"clientId"`
+}
+
+type chatMessageAck struct {
+	ClientID string             `json:"clientId"`
+	Message  models.ChatMessage `json:"message"`
+}
+
+func handleChatMessage(c *Client, payload json.RawMessage) error {
+	if err := services.Authorize(c.role, auth.PermSendMessage); err != nil {
+		return err
+	}
+	var frame chatMessageFrame
+	if err := json.Unmarshal(payload, &frame); err != nil {
+		return services.ValidationError("invalid chat message")
+	}
+	if frame.ReceiverID <= 0 {
+		return services.FieldErrors(map[string]string{"receiverId": "receiverId is required"})
+	}
+
+	chatService := services.ChatService{DB: c.db}
+	msg, err := chatService.SaveMessage(c.userID, frame.ReceiverID, frame.Content)
+	if err != nil {
+		return err
+	}
+
+	// Sending a message ends the sender's typing indicator
+	c.hub.typing.stop(c.userID, frame.ReceiverID)
+
+	chatMsg := NewChatMessage(msg, c.nickname)
+	c.hub.deliverChatMessage(c.db, &chatMsg, c)
+	c.hub.sendToClient(c, encodeMessage("chat_message_ack", chatMessageAck{
+		ClientID: frame.ClientID,
+		Message:  chatMsg,
+	}))
+	return nil
+}
+
+type typingFrame struct {
+	ReceiverID int `json:"receiverId"`
+}
+
+func decodeTypingFrame(c *Client, payload json.RawMessage) (int, error) {
+	var frame typingFrame
+	if err := json.Unmarshal(payload, &frame); err != nil {
+		return 0, services.ValidationError("invalid typing frame")
+	}
+	if frame.ReceiverID <= 0 || frame.ReceiverID == c.userID {
+		return 0, services.FieldErrors(map[string]string{"receiverId": "invalid receiverId"})
+	}
+	return frame.ReceiverID, nil
+}
+
+func handleTypingStart(c *Client, payload json.RawMessage) error {
+	receiverID, err := decodeTypingFrame(c, payload)
+	if err != nil {
+		return err
+	}
+	c.hub.typing.start(c.userID, receiverID)
+	return nil
+}
+
+func handleTypingStop(c *Client, payload json.RawMessage) error {
+	receiverID, err := decodeTypingFrame(c, payload)
+	if err != nil {
+		return err
+	}
+	c.hub.typing.stop(c.userID, receiverID)
+	return nil
+}
+
+// notifyTyping relays a typing indicator change to the conversation
+// partner only.
+func (h *Hub) notifyTyping(from, to int, typing bool) {
+	h.SendToUser(to, encodeMessage("user_typing", map[string]interface{}{
+		"userId": from,
+		"typing": typing,
+	}))
+}
+
+func handleMessageRead(c *Client, payload json.RawMessage) error {
+	var frame struct {
+		MessageID int `json:"messageId"`
+	}
+	if err := json.Unmarshal(payload, &frame); err != nil || frame.MessageID <= 0 {
+		return services.FieldErrors(map[string]string{"messageId": "invalid messageId"})
+	}
+
+	chatService := services.ChatService{DB: c.db}
+	senderID, err := chatService.MarkMessageRead(c.userID, frame.MessageID)
+	if err != nil {
+		return err
+	}
+
+	c.hub.PushReadReceipt(c.userID, senderID, frame.MessageID)
+	c.hub.PushConversationUpdate(c.db, c.userID, senderID)
+	return nil
+}
+
+// PushReadReceipt tells senderID that readerID has read their messages up
+// to and including messageID.
+func (h *Hub) PushReadReceipt(readerID, senderID, messageID int) {
+	h.SendToUser(senderID, encodeMessage("message_read", models.ReadReceipt{
+		ReaderID:  readerID,
+		MessageID: messageID,
+		ReadAt:    time.Now().UTC().Format(time.RFC3339),
+	}))
+}
+
+// NewChatMessage builds the payload pushed to clients for a stored message.
+func NewChatMessage(msg *models.Message, senderNickname string) models.ChatMessage {
+	return models.ChatMessage{
+		ID:         msg.ID,
+		SenderID:   msg.SenderID,
+		ReceiverID: msg.ReceiverID,
+		Sender:     senderNickname,
+		Content:    msg.Content,
+		Timestamp:  msg.CreatedAt.Format(time.RFC3339),
+		Status:     msg.Status,
+	}
+}
+
+// DeliverChatMessage pushes a stored message to every connection of its
+// receiver and its sender, followed by each side's updated conversation
+// summary. If the receiver has a live connection the message is marked
+// delivered and msg.Status updated.
+func (h *Hub) DeliverChatMessage(db *sql.DB, msg *models.ChatMessage) {
+	h.deliverChatMessage(db, msg, nil)
+}
+
+// deliverChatMessage is DeliverChatMessage but skips origin, the connection
+// the message was sent from, which is acked separately.
+func (h *Hub) deliverChatMessage(db *sql.DB, msg *models.ChatMessage, origin *Client) {
+	if h.SendToUser(msg.ReceiverID, encodeMessage("chat_message", msg)) > 0 {
+		chatService := services.ChatService{DB: db}
+		if err := chatService.MarkDelivered(msg.ID); err != nil {
+			log.Printf("Mark delivered failed: %v", err)
+		} else {
+			msg.Status = "delivered"
+		}
+	}
+	h.sendToUserExcept(msg.SenderID, encodeMessage("chat_message", msg), origin)
+
+	h.PushConversationUpdate(db, msg.ReceiverID, msg.SenderID)
+	h.PushConversationUpdate(db, msg.SenderID, msg.ReceiverID)
+}
+
+// PushConversationUpdate sends userID's current summary of their
+// conversation with otherUserID to all of userID's connections.
+func (h *Hub) PushConversationUpdate(db *sql.DB, userID, otherUserID int) {
+	chatService := services.ChatService{DB: db}
+	conversation, err := chatService.GetConversation(userID, otherUserID)
+	if err != nil {
+		log.Printf("Conversation update failed: %v", err)
+		return
+	}
+	if conversation == nil {
+		return
+	}
+	h.SendToUser(userID, encodeMessage("conversation_update", conversation))
+}
+
+// errorFrame reports a failed request to the connection that sent it. Code
+// and Fields mean the same as in the HTTP API's error envelope.
+type errorFrame struct {
+	Type    string            `json:"type"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	// RetryAfter is set, in seconds, when the frame was rate limited.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+// sendError reports err back to c. Only a *services.Error's message is
+// passed on; anything else is logged and reported as an internal error.
+func (c *Client) sendError(requestType string, err error) {
+	frame := errorFrame{
+		Type:    requestType,
+		Code:    "internal_error",
+		Message: "Something went wrong. Please try again.",
+	}
+	var serviceErr *services.Error
+	var limitErr *ratelimit.Error
+	if errors.As(err, &limitErr) {
+		frame.Code = "rate_limited"
+		frame.Message = "Slow down; too many messages."
+		frame.RetryAfter = limitErr.Seconds()
+	} else if errors.As(err, &serviceErr) {
+		frame.Code = serviceErr.Kind.Code()
+		frame.Message = serviceErr.Message
+		frame.Fields = serviceErr.Fields
+	} else {
+		log.Printf("%s from %s failed: %v", requestType, c.nickname, err)
+	}
+	c.hub.sendToClient(c, encodeMessage("error", frame))
+}
+
+func encodeMessage(msgType string, payload interface{}) []byte {
+	data, err := json.Marshal(models.WebSocketMessage{Type: msgType, Payload: payload})
+	if err != nil {
+		log.Printf("Error marshaling %s message: %v", msgType, err)
+	}
+	return data
+}
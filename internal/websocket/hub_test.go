@@ -0,0 +1,290 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeClient is a registered client without a connection. Its writer
+// goroutine stands in for writePump: it records everything queued for the
+// client until the hub closes send.
+type fakeClient struct {
+	*Client
+	mu       sync.Mutex
+	received [][]byte
+	closed   chan struct{}
+}
+
+// newFakeClient registers a client for userID. It may be called from any
+// goroutine; it returns nil if the hub refused the client.
+func newFakeClient(t *testing.T, h *Hub, userID, buffer int) *fakeClient {
+	t.Helper()
+	fc := &fakeClient{
+		Client: &Client{
+			hub:      h,
+			send:     make(chan []byte, buffer),
+			userID:   userID,
+			nickname: "user",
+		},
+		closed: make(chan struct{}),
+	}
+	if !h.registerClient(fc.Client) {
+		t.Errorf("registerClient failed for user %d", userID)
+		return nil
+	}
+	return fc
+}
+
+// startWriter drains the client's send channel like writePump would.
+func (fc *fakeClient) startWriter() {
+	go func() {
+		defer fc.hub.writers.Done()
+		for msg := range fc.send {
+			fc.mu.Lock()
+			fc.received = append(fc.received, msg)
+			fc.mu.Unlock()
+		}
+		close(fc.closed)
+	}()
+}
+
+func (fc *fakeClient) messages() [][]byte {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([][]byte(nil), fc.received...)
+}
+
+// runHub starts h and returns a function that stops it and waits for Run
+// to return.
+func runHub(t *testing.T, h *Hub) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		h.Run(ctx)
+		close(stopped)
+	}()
+	return func() {
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not return after cancel")
+		}
+	}
+}
+
+type fakeLastSeen struct {
+	mu   sync.Mutex
+	seen map[int]time.Time
+}
+
+func (s *fakeLastSeen) TouchLastSeen(userID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen == nil {
+		s.seen = make(map[int]time.Time)
+	}
+	s.seen[userID] = at
+	return nil
+}
+
+func TestHubConcurrentRegisterUnregisterBroadcast(t *testing.T) {
+	h := NewHub(&fakeLastSeen{})
+	stop := runHub(t, h)
+
+	const workers = 32
+	const rounds = 20
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				fc := newFakeClient(t, h, w%5+1, 4)
+				if fc == nil {
+					return
+				}
+				fc.startWriter()
+				h.BroadcastEvent("test", r)
+				h.SendToUser(fc.userID, encodeMessage("direct", r))
+				h.GetOnlineUsers()
+				h.IsOnline(fc.userID)
+				h.unregisterClient(fc.Client)
+			}
+		}(w)
+	}
+
+	// Broadcast and disconnect concurrently with the churn above
+	var noise sync.WaitGroup
+	noise.Add(2)
+	go func() {
+		defer noise.Done()
+		for i := 0; i < workers*rounds; i++ {
+			h.SendMessage(encodeMessage("noise", i))
+		}
+	}()
+	go func() {
+		defer noise.Done()
+		for i := 0; i < workers*rounds; i++ {
+			h.typing.start(i%5+1, i%7+1)
+			h.typing.stopAll(i%5 + 1)
+		}
+	}()
+
+	wg.Wait()
+	noise.Wait()
+	stop()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) != 0 || len(h.users) != 0 {
+		t.Fatalf("clients left after all unregistered: %d clients, %d users", len(h.clients), len(h.users))
+	}
+	if online := h.presence.online(); len(online) != 0 {
+		t.Fatalf("users still online: %v", online)
+	}
+}
+
+func TestHubSlowClientRemovedOnce(t *testing.T) {
+	h := NewHub(nil)
+	stop := runHub(t, h)
+
+	slow := newFakeClient(t, h, 1, 1)
+	// Fill the buffer and overflow it so the hub drops the client, then
+	// unregister it as readPump would; send must only be closed once.
+	h.SendMessage(encodeMessage("first", nil))
+	h.SendMessage(encodeMessage("overflow", nil))
+	h.SendToUser(1, encodeMessage("overflow", nil))
+	h.unregisterClient(slow.Client)
+
+	slow.startWriter()
+	select {
+	case <-slow.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow client's send channel was not closed")
+	}
+	stop()
+
+	if got := len(slow.messages()); got != 1 {
+		t.Fatalf("slow client received %d messages, want 1", got)
+	}
+	if slow.closeCode != websocket.CloseTryAgainLater {
+		t.Fatalf("close code = %d, want %d", slow.closeCode, websocket.CloseTryAgainLater)
+	}
+}
+
+func TestHubShutdownClosesClients(t *testing.T) {
+	store := &fakeLastSeen{}
+	h := NewHub(store)
+	stop := runHub(t, h)
+
+	var clients []*fakeClient
+	for id := 1; id <= 3; id++ {
+		fc := newFakeClient(t, h, id, 8)
+		fc.startWriter()
+		clients = append(clients, fc)
+	}
+	h.BroadcastEvent("bye", nil)
+	stop()
+
+	for _, fc := range clients {
+		select {
+		case <-fc.closed:
+		default:
+			t.Fatalf("client %d still open after shutdown", fc.userID)
+		}
+		if fc.closeCode != websocket.CloseGoingAway {
+			t.Errorf("client %d close code = %d, want %d", fc.userID, fc.closeCode, websocket.CloseGoingAway)
+		}
+		var sawBye bool
+		for _, msg := range fc.messages() {
+			var frame struct{ Type string }
+			json.Unmarshal(msg, &frame)
+			sawBye = sawBye || frame.Type == "bye"
+		}
+		if !sawBye {
+			t.Errorf("client %d did not receive the broadcast queued before shutdown", fc.userID)
+		}
+	}
+
+	store.mu.Lock()
+	if len(store.seen) != 3 {
+		t.Errorf("last seen recorded for %d users at shutdown, want 3", len(store.seen))
+	}
+	store.mu.Unlock()
+
+	// A stopped hub must not block or accept anything
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if h.registerClient(&Client{hub: h, send: make(chan []byte, 1), userID: 9}) {
+			t.Error("registerClient succeeded after shutdown")
+		}
+		h.BroadcastEvent("late", nil)
+		h.unregisterClient(clients[0].Client)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub calls blocked after shutdown")
+	}
+}
+
+func TestHubPresenceDeduplicatesConnections(t *testing.T) {
+	store := &fakeLastSeen{}
+	h := NewHub(store)
+	stop := runHub(t, h)
+
+	observer := newFakeClient(t, h, 99, 16)
+	observer.startWriter()
+
+	first := newFakeClient(t, h, 1, 16)
+	first.startWriter()
+	second := newFakeClient(t, h, 1, 16)
+	second.startWriter()
+
+	if users := h.GetOnlineUsers(); len(users) != 2 {
+		t.Fatalf("online users = %v, want user 1 and the observer once each", users)
+	}
+
+	h.unregisterClient(first.Client)
+	// Unregistering is processed by Run; a register round-trip orders us
+	// after it.
+	probe := newFakeClient(t, h, 50, 1)
+	probe.startWriter()
+	if !h.IsOnline(1) {
+		t.Fatal("user 1 went offline while a connection was still open")
+	}
+
+	h.unregisterClient(second.Client)
+	h.unregisterClient(probe.Client)
+	probe = newFakeClient(t, h, 51, 1)
+	probe.startWriter()
+	if h.IsOnline(1) {
+		t.Fatal("user 1 still online after closing every connection")
+	}
+	// Stopping the hub closes the observer, so all its frames are in
+	stop()
+
+	var events []PresenceEvent
+	for _, msg := range observer.messages() {
+		var frame struct {
+			Type    string
+			Payload PresenceEvent
+		}
+		json.Unmarshal(msg, &frame)
+		if frame.Type == "presence" && frame.Payload.UserID == 1 {
+			events = append(events, frame.Payload)
+		}
+	}
+	if len(events) != 2 || !events[0].Online || events[1].Online {
+		t.Fatalf("presence events for user 1 = %+v, want one online then one offline", events)
+	}
+}
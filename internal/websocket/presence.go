@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"real-time-forum/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LastSeenStore persists when a user was last seen online.
+type LastSeenStore interface {
+	TouchLastSeen(userID int, at time.Time) error
+}
+
+// PresenceEvent is broadcast as a "presence" frame when a user's first
+// connection opens or their last one closes.
+type PresenceEvent struct {
+	UserID     int       `json:"userId"`
+	Nickname   string    `json:"nickname"`
+	Online     bool      `json:"online"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+// presenceTracker counts open connections per user, so a user with several
+// tabs or devices is listed once and only changes state on their first
+// connect and last disconnect.
+type presenceTracker struct {
+	mu        sync.Mutex
+	conns     map[int]int
+	nicknames map[int]string
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		conns:     make(map[int]int),
+		nicknames: make(map[int]string),
+	}
+}
+
+// connect records a new connection and reports whether it is the user's
+// first.
+func (p *presenceTracker) connect(userID int, nickname string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.conns[userID]++
+	p.nicknames[userID] = nickname
+	return p.conns[userID] == 1
+}
+
+// disconnect records a closed connection and reports whether it was the
+// user's last.
+func (p *presenceTracker) disconnect(userID int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conns[userID] == 0 {
+		return false
+	}
+	p.conns[userID]--
+	if p.conns[userID] > 0 {
+		return false
+	}
+	delete(p.conns, userID)
+	delete(p.nicknames, userID)
+	return true
+}
+
+func (p *presenceTracker) isOnline(userID int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conns[userID] > 0
+}
+
+// online lists each connected user once, sorted by nickname.
+func (p *presenceTracker) online() []models.User {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users := make([]models.User, 0, len(p.nicknames))
+	for id, nickname := range p.nicknames {
+		users = append(users, models.User{ID: id, Nickname: nickname})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Nickname < users[j].Nickname })
+	return users
+}
@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+// TypingTimeout is how long a typing indicator stays on without a fresh
+// typing_start from the client.
+const TypingTimeout = 6 * time.Second
+
+type typingKey struct {
+	from, to int
+}
+
+// typingTracker remembers who is typing to whom and switches the indicator
+// off when the typist goes quiet, stops explicitly or disconnects.
+type typingTracker struct {
+	mu      sync.Mutex
+	timers  map[typingKey]*time.Timer
+	timeout time.Duration
+	// notify tells to that from started or stopped typing. It is never
+	// called with mu held.
+	notify func(from, to int, typing bool)
+}
+
+func newTypingTracker(timeout time.Duration, notify func(from, to int, typing bool)) *typingTracker {
+	return &typingTracker{
+		timers:  make(map[typingKey]*time.Timer),
+		timeout: timeout,
+		notify:  notify,
+	}
+}
+
+// start marks from as typing to to, or extends an existing indicator.
+func (t *typingTracker) start(from, to int) {
+	key := typingKey{from, to}
+
+	t.mu.Lock()
+	timer, active := t.timers[key]
+	if active {
+		timer.Stop()
+	}
+	var expire *time.Timer
+	expire = time.AfterFunc(t.timeout, func() {
+		t.mu.Lock()
+		// A later start may have replaced this timer
+		if t.timers[key] != expire {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.timers, key)
+		t.mu.Unlock()
+		t.notify(from, to, false)
+	})
+	t.timers[key] = expire
+	t.mu.Unlock()
+
+	if !active {
+		t.notify(from, to, true)
+	}
+}
+
+// stop clears the indicator from from to to, if there is one.
+func (t *typingTracker) stop(from, to int) {
+	key := typingKey{from, to}
+
+	t.mu.Lock()
+	timer, active := t.timers[key]
+	if active {
+		timer.Stop()
+		delete(t.timers, key)
+	}
+	t.mu.Unlock()
+
+	if active {
+		t.notify(from, to, false)
+	}
+}
+
+// stopAll clears every indicator from is showing, e.g. when they go offline.
+func (t *typingTracker) stopAll(from int) {
+	t.mu.Lock()
+	var stopped []int
+	for key, timer := range t.timers {
+		if key.from == from {
+			timer.Stop()
+			delete(t.timers, key)
+			stopped = append(stopped, key.to)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, to := range stopped {
+		t.notify(from, to, false)
+	}
+}
+
+// close cancels every pending expiry without notifying anyone.
+func (t *typingTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, timer := range t.timers {
+		timer.Stop()
+		delete(t.timers, key)
+	}
+}
@@ -1,88 +1,329 @@
 package websocket
 
 import (
-	"encoding/json"
+	"context"
 	"log"
+	"real-time-forum/internal/config"
 	"real-time-forum/internal/models"
+	"real-time-forum/internal/ratelimit"
 	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 type Hub struct {
-	clients    map[*Client]bool
+	clients map[*Client]bool
+	// users indexes clients by user ID; a user may hold several
+	// connections at once (multiple tabs or devices).
+	users      map[int]map[*Client]bool
 	Broadcast  chan []byte
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.Mutex
+	// closed is set under mu once the hub has shut down; done is closed at
+	// the same time so senders on the channels above never block on a hub
+	// that has stopped running.
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+	// writers counts registered clients whose writePump hasn't returned.
+	writers sync.WaitGroup
+
+	typing   *typingTracker
+	presence *presenceTracker
+	lastSeen LastSeenStore
+	// upgrader and pump apply to every connection served after Configure.
+	upgrader websocket.Upgrader
+	pump     config.WebSocketConfig
+	// frames limits the frames each user sends; nil is unlimited.
+	frames *ratelimit.Limiter
 }
 
-func NewHub() *Hub {
-	return &Hub{
+// NewHub creates a hub that records users' last-seen times in lastSeen,
+// which may be nil.
+func NewHub(lastSeen LastSeenStore) *Hub {
+	h := &Hub{
 		clients:    make(map[*Client]bool),
+		users:      make(map[int]map[*Client]bool),
 		Broadcast:  make(chan []byte),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		done:       make(chan struct{}),
+		presence:   newPresenceTracker(),
+		lastSeen:   lastSeen,
 	}
+	h.Configure(config.Default().WebSocket)
+	h.typing = newTypingTracker(TypingTimeout, h.notifyTyping)
+	return h
 }
 
-func (h *Hub) Run() {
+// Run serves registrations, unregistrations and broadcasts until ctx is
+// cancelled. It then shuts the hub down: every client is sent what is
+// already queued for it followed by a close frame, and Run returns once
+// their write pumps have finished.
+func (h *Hub) Run(ctx context.Context) {
 	for {
 		select {
+		case <-ctx.Done():
+			h.shutdown()
+			return
+
 		case client := <-h.register:
 			h.mu.Lock()
-			h.clients[client] = true
+			h.addClient(client)
 			h.mu.Unlock()
+			if h.presence.connect(client.userID, client.nickname) {
+				h.announcePresence(client, true)
+			}
 			log.Printf("Client registered: %s", client.nickname)
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
+			h.removeClient(client)
 			h.mu.Unlock()
+			// Presence follows register/unregister rather than h.users, which
+			// also loses clients dropped for being slow.
+			if h.presence.disconnect(client.userID) {
+				h.typing.stopAll(client.userID)
+				h.announcePresence(client, false)
+			}
 			log.Printf("Client unregistered: %s", client.nickname)
 
 		case message := <-h.Broadcast:
-			h.mu.Lock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+			h.broadcast(message)
+		}
+	}
+}
+
+// shutdown stops the hub accepting clients and closes every connection.
+func (h *Hub) shutdown() {
+	h.closeOnce.Do(func() { close(h.done) })
+
+	h.mu.Lock()
+	h.closed = true
+	for client := range h.clients {
+		client.closeCode = websocket.CloseGoingAway
+		client.closeReason = "server shutting down"
+		h.removeClient(client)
+	}
+	h.mu.Unlock()
+
+	h.typing.close()
+	if h.lastSeen != nil {
+		now := time.Now().UTC().Truncate(time.Second)
+		for _, user := range h.presence.online() {
+			if err := h.lastSeen.TouchLastSeen(user.ID, now); err != nil {
+				log.Printf("Failed to record last seen for %s: %v", user.Nickname, err)
 			}
-			h.mu.Unlock()
 		}
 	}
+
+	h.writers.Wait()
+	log.Println("WebSocket hub stopped")
 }
-func (h *Hub) GetOnlineUsers() []models.User {
+
+// registerClient hands client to Run. It reports false if the hub has shut
+// down, in which case the client must not start its pumps. On success the
+// client's writePump must call h.writers.Done when it returns.
+func (h *Hub) registerClient(client *Client) bool {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return false
+	}
+	h.writers.Add(1)
+	h.mu.Unlock()
+
+	select {
+	case h.register <- client:
+		return true
+	case <-h.done:
+		h.writers.Done()
+		return false
+	}
+}
+
+// unregisterClient hands client back to Run. After shutdown every client
+// has already been removed, so there is nothing left to do.
+func (h *Hub) unregisterClient(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
+}
+
+// addClient and removeClient must be called with h.mu held. removeClient
+// may be called more than once for the same client.
+func (h *Hub) addClient(client *Client) {
+	h.clients[client] = true
+	if h.users[client.userID] == nil {
+		h.users[client.userID] = make(map[*Client]bool)
+	}
+	h.users[client.userID][client] = true
+}
+
+func (h *Hub) removeClient(client *Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	delete(h.clients, client)
+	if conns := h.users[client.userID]; conns != nil {
+		delete(conns, client)
+		if len(conns) == 0 {
+			delete(h.users, client.userID)
+		}
+	}
+	close(client.send)
+}
+
+// dropSlowClient removes a client whose send buffer is full; its writePump
+// tells the peer why before closing. Must be called with h.mu held.
+func (h *Hub) dropSlowClient(client *Client) {
+	client.closeCode = websocket.CloseTryAgainLater
+	client.closeReason = "too many queued messages"
+	h.removeClient(client)
+}
+
+// broadcast queues msg on every connection, dropping slow ones.
+func (h *Hub) broadcast(msg []byte) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	users := make([]models.User, 0, len(h.clients))
 	for client := range h.clients {
-		users = append(users, models.User{
-			ID:       client.userID,
-			Nickname: client.nickname,
-		})
+		select {
+		case client.send <- msg:
+		default:
+			h.dropSlowClient(client)
+		}
 	}
-	return users
 }
 
-func (h *Hub) BroadcastOnlineUsers() {
-	onlineUsers := h.GetOnlineUsers()
-	msg := models.WebSocketMessage{
-		Type:    "online_users",
-		Payload: onlineUsers,
+// announcePresence records the user's last-seen time and tells every
+// client that they came online or went offline.
+func (h *Hub) announcePresence(client *Client, online bool) {
+	now := time.Now().UTC().Truncate(time.Second)
+	if h.lastSeen != nil {
+		if err := h.lastSeen.TouchLastSeen(client.userID, now); err != nil {
+			log.Printf("Failed to record last seen for %s: %v", client.nickname, err)
+		}
 	}
-	messageBytes, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("Error marshaling online users: %v", err)
+	h.broadcast(encodeMessage("presence", PresenceEvent{
+		UserID:     client.userID,
+		Nickname:   client.nickname,
+		Online:     online,
+		LastSeenAt: now,
+	}))
+}
+
+// SendToUser queues msg on every connection the user currently holds and
+// reports how many connections it was queued on. Connections whose send
+// buffer is full are dropped, as in broadcast.
+func (h *Hub) SendToUser(userID int, msg []byte) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sent := 0
+	for client := range h.users[userID] {
+		select {
+		case client.send <- msg:
+			sent++
+		default:
+			h.dropSlowClient(client)
+		}
+	}
+	return sent
+}
+
+// sendToUserExcept is SendToUser but skips the except connection.
+func (h *Hub) sendToUserExcept(userID int, msg []byte, except *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.users[userID] {
+		if client == except {
+			continue
+		}
+		select {
+		case client.send <- msg:
+		default:
+			h.dropSlowClient(client)
+		}
+	}
+}
+
+// sendToClient queues msg on a single connection.
+func (h *Hub) sendToClient(client *Client, msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[client]; !ok {
 		return
 	}
-	h.Broadcast <- messageBytes
+	select {
+	case client.send <- msg:
+	default:
+		h.dropSlowClient(client)
+	}
+}
+
+// GetOnlineUsers lists each connected user once, however many connections
+// they hold.
+func (h *Hub) GetOnlineUsers() []models.User {
+	return h.presence.online()
+}
+
+// IsOnline reports whether the user holds at least one connection.
+func (h *Hub) IsOnline(userID int) bool {
+	return h.presence.isOnline(userID)
+}
+
+// DisconnectSession closes every connection opened with the given session,
+// e.g. after the session was revoked or logged out.
+func (h *Hub) DisconnectSession(sessionID int) {
+	h.disconnect(websocket.ClosePolicyViolation, "session revoked", func(c *Client) bool { return c.sessionID == sessionID })
+}
+
+// DisconnectUser closes every connection belonging to the user.
+func (h *Hub) DisconnectUser(userID int) {
+	h.disconnect(websocket.ClosePolicyViolation, "session revoked", func(c *Client) bool { return c.userID == userID })
+}
+
+// RefreshUser closes every connection belonging to the user with "service
+// restart", telling the client its session is fine but should reconnect to
+// pick up changes to the account, such as a new role.
+func (h *Hub) RefreshUser(userID int) {
+	h.disconnect(websocket.CloseServiceRestart, "account changed", func(c *Client) bool { return c.userID == userID })
+}
+
+func (h *Hub) disconnect(code int, reason string, match func(*Client) bool) {
+	h.mu.Lock()
+	var matched []*Client
+	for client := range h.clients {
+		if match(client) {
+			matched = append(matched, client)
+		}
+	}
+	h.mu.Unlock()
+
+	// Closing the connection makes readPump return, which unregisters the client.
+	closeMsg := websocket.FormatCloseMessage(code, reason)
+	for _, client := range matched {
+		client.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		client.conn.Close()
+	}
+}
+
+// BroadcastEvent sends a typed message to every connected client.
+func (h *Hub) BroadcastEvent(msgType string, payload interface{}) {
+	h.SendMessage(encodeMessage(msgType, payload))
 }
+
+// SendMessage broadcasts an encoded message. It is dropped if the hub has
+// shut down.
 func (h *Hub) SendMessage(messageBytes []byte) {
-	h.Broadcast <- messageBytes
+	select {
+	case h.Broadcast <- messageBytes:
+	case <-h.done:
+	}
 }
@@ -1,31 +1,183 @@
+// Command real-time-forum serves the forum. Search needs SQLite's FTS5
+// module, so build with make, or with:
+//
+//	go build -tags sqlite_fts5
+//
+// A binary built without the tag refuses to start.
+//
+// Settings come from forum.toml, FORUM_* environment variables and flags;
+// see forum.example.toml and -help. "forum [flags] migrate ..." manages the
+// database schema instead of serving, and "forum [flags] role <user> <role>"
+// sets a user's role, which is how the first admin is made.
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"real-time-forum/internal/api"
+	"real-time-forum/internal/auth"
+	"real-time-forum/internal/config"
 	"real-time-forum/internal/database"
+	"real-time-forum/internal/ratelimit"
+	"real-time-forum/internal/services"
 	"real-time-forum/internal/websocket"
+	"sync"
+	"syscall"
 )
 
 func main() {
-	// Initialize database
-	db, err := database.InitDB("./database/forum.db")
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatal("Database initialization failed:", err)
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	// "migrate ..." manages the schema and exits without starting the server
+	if len(args) > 0 && args[0] == "migrate" {
+		db, err := database.Open(cfg.Database.Path)
+		if err != nil {
+			log.Fatal("Database open failed:", err)
+		}
+		defer db.Close()
+		if err := database.RunMigrateCommand(db, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == "role" {
+		if err := runRoleCommand(cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 {
+		log.Fatalf("Unknown command %q", args[0])
+	}
+
+	if err := serve(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runRoleCommand gives the user with the email or nickname args[0] the role
+// args[1]. Only admins can change roles through the API, so the first one
+// has to be made here.
+func runRoleCommand(cfg *config.Config, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: forum [flags] role <email-or-nickname> <admin|moderator|member>")
+	}
+	login, role := args[0], args[1]
+	if !auth.ValidRole(role) {
+		return fmt.Errorf("unknown role %q; use admin, moderator or member", role)
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("database initialization failed: %w", err)
 	}
 	defer db.Close()
 
-	// Initialize WebSocket hub
-	hub := websocket.NewHub()
-	go hub.Run()
+	userService := services.UserService{DB: db}
+	userID, err := userService.FindUserID(login)
+	if err != nil {
+		return fmt.Errorf("%s: %w", login, err)
+	}
+	moderationService := services.ModerationService{DB: db}
+	if err := moderationService.SetRole(userID, role); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", login, role)
+	return nil
+}
 
-	// Set up routes
-	router := api.SetupRouter(db, hub)
+// serve runs the server until it fails or receives SIGINT or SIGTERM. On a
+// signal it stops accepting connections, closes every websocket with a
+// close frame, waits for in-flight requests and closes the database.
+func serve(cfg *config.Config) error {
+	if err := cfg.EnsureSecret(); err != nil {
+		return err
+	}
 
-	// Start server
-	log.Println("Server starting on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", router); err != nil {
-		log.Fatal("Server failed:", err)
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("database initialization failed: %w", err)
+	}
+
+	hub := websocket.NewHub(&services.UserService{DB: db})
+	hub.Configure(cfg.WebSocket)
+
+	limits := ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Enabled {
+		hub.LimitFrames(ratelimit.NewLimiter(limits, "ws", cfg.RateLimit.WebSocket))
+	}
+
+	// Mail sent after a response still needs the database
+	var background sync.WaitGroup
+	router, err := api.SetupRouter(db, hub, cfg, limits, &background)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	hubDone := make(chan struct{})
+	go func() {
+		hub.Run(hubCtx)
+		close(hubDone)
+	}()
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	// Websockets are hijacked, so Shutdown doesn't track them; the hub
+	// closes them as soon as shutdown begins.
+	srv.RegisterOnShutdown(stopHub)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Server starting on %s", cfg.Server.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+		err = fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+		// A second signal kills the process without waiting
+		stop()
+		log.Println("Shutting down...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP shutdown incomplete: %v", err)
+		}
+	}
+
+	stopHub()
+	<-hubDone
+	background.Wait()
+	if closeErr := db.Close(); closeErr != nil {
+		log.Printf("Database close failed: %v", closeErr)
+	}
+	if err == nil {
+		log.Println("Server stopped")
 	}
+	return err
 }